@@ -0,0 +1,442 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"reflect"
+	"strconv"
+	"strings"
+
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics"
+)
+
+const (
+	goMetricsPath = "github.com/rcrowley/go-metrics"
+	histogramPath = "github.com/ybriffa/metrics/histogram"
+	metricsPath   = "github.com/ybriffa/metrics"
+)
+
+// generator writes the constructor and the registration code of the structs of a package
+type generator struct {
+	pkg string
+	// structs are the struct types declared in the package, by name
+	structs map[string]*structType
+	// loads are the kinds returned by the Load methods of the types declared in the package, by type name
+	loads map[string]string
+
+	// buffers of the code instantiating and registering the metrics of the type being generated
+	newBuf      bytes.Buffer
+	registerBuf bytes.Buffer
+
+	usesHistogram bool
+	usesAtomic    bool
+	errs          []string
+	names         map[string]string
+	visiting      map[string]struct{}
+}
+
+// structType is a struct declared in the package, with the file declaring it to resolve its imports
+type structType struct {
+	name string
+	node *ast.StructType
+	file *ast.File
+}
+
+func newGenerator(pkg string, files []*ast.File) *generator {
+	g := &generator{
+		pkg:     pkg,
+		structs: map[string]*structType{},
+		loads:   map[string]string{},
+	}
+
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch decl := n.(type) {
+			case *ast.TypeSpec:
+				if st, ok := decl.Type.(*ast.StructType); ok {
+					g.structs[decl.Name.Name] = &structType{name: decl.Name.Name, node: st, file: file}
+				}
+				return false
+			case *ast.FuncDecl:
+				if decl.Recv != nil && len(decl.Recv.List) == 1 && decl.Name.Name == "Load" {
+					if kind := funcResultKind(decl.Type); kind != "" {
+						g.loads[typeName(decl.Recv.List[0].Type)] = kind
+					}
+				}
+				return false
+			}
+			return true
+		})
+	}
+
+	return g
+}
+
+// generate returns the formatted source of the code for the given types, or an error
+// listing every invalid field
+func (g *generator) generate(typeNames []string) ([]byte, error) {
+	var body bytes.Buffer
+	for _, typeName := range typeNames {
+		st, exists := g.structs[typeName]
+		if !exists {
+			g.errs = append(g.errs, fmt.Sprintf("%s : struct type not found", typeName))
+			continue
+		}
+
+		g.newBuf.Reset()
+		g.registerBuf.Reset()
+		g.names = map[string]string{}
+		g.visiting = map[string]struct{}{}
+
+		g.walk(st, "m", "", typeName)
+
+		fmt.Fprintf(&body, "// New%s returns a %s with all its metrics instantiated.\n", typeName, typeName)
+		fmt.Fprintf(&body, "func New%s() *%s {\n", typeName, typeName)
+		fmt.Fprintf(&body, "m := &%s{}\n", typeName)
+		body.Write(g.newBuf.Bytes())
+		fmt.Fprintf(&body, "return m\n}\n\n")
+
+		fmt.Fprintf(&body, "// Registry returns a registry holding the metrics of the %s, named as\n", typeName)
+		fmt.Fprintf(&body, "// metrics.RegistryFromStruct would name them.\n")
+		fmt.Fprintf(&body, "func (m *%s) Registry() gometrics.Registry {\n", typeName)
+		fmt.Fprintf(&body, "r := metrics.NewTaggedRegistry()\n")
+		fmt.Fprintf(&body, "// The tags were checked by metricsgen, the registrations cannot fail\n")
+		body.Write(g.registerBuf.Bytes())
+		fmt.Fprintf(&body, "return r\n}\n\n")
+
+		fmt.Fprintf(&body, "// Register registers the metrics of the %s to be sent by the drivers.\n", typeName)
+		fmt.Fprintf(&body, "func (m *%s) Register(name string, tags map[string]string) {\n", typeName)
+		fmt.Fprintf(&body, "metrics.Register(name, m.Registry(), tags)\n}\n\n")
+	}
+
+	if len(g.errs) > 0 {
+		return nil, errors.New(strings.Join(g.errs, "\n"))
+	}
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "// Code generated by metricsgen -type=%s; DO NOT EDIT.\n\n", strings.Join(typeNames, ","))
+	fmt.Fprintf(&src, "package %s\n\n", g.pkg)
+	fmt.Fprintf(&src, "import (\n")
+	fmt.Fprintf(&src, "gometrics %q\n", goMetricsPath)
+	fmt.Fprintf(&src, "%q\n", metricsPath)
+	if g.usesHistogram {
+		fmt.Fprintf(&src, "%q\n", histogramPath)
+	}
+	if g.usesAtomic {
+		fmt.Fprintf(&src, "%q\n", "sync/atomic")
+	}
+	fmt.Fprintf(&src, ")\n\n")
+	src.Write(body.Bytes())
+
+	return format.Source(src.Bytes())
+}
+
+// walk writes the instantiation and the registration of the metrics of the struct st,
+// accessed through the expression expr. The rules are the ones of metrics.RegistryFromStruct.
+func (g *generator) walk(st *structType, expr, prefix, path string) {
+	g.visiting[st.name] = struct{}{}
+	defer delete(g.visiting, st.name)
+
+	for _, field := range st.node.Fields.List {
+		tag := reflect.StructTag("")
+		if field.Tag != nil {
+			raw, err := strconv.Unquote(field.Tag.Value)
+			if err == nil {
+				tag = reflect.StructTag(raw)
+			}
+		}
+
+		// Embedded fields are named after their type
+		fieldNames := []string{}
+		for _, name := range field.Names {
+			fieldNames = append(fieldNames, name.Name)
+		}
+		embedded := len(fieldNames) == 0
+		if embedded {
+			fieldNames = append(fieldNames, typeName(field.Type))
+		}
+
+		for _, fieldName := range fieldNames {
+			fieldPath := path + "." + fieldName
+			fieldExpr := expr + "." + fieldName
+
+			tagName := tag.Get("metrics")
+			name := fieldName
+			if tagName != "" {
+				name = tagName
+			}
+			name = prefix + strings.ToLower(name)
+
+			// Embedded structs of the package are explored whether they are exported or not
+			if nested, isPointer, ok := g.nestedStruct(field.Type); ok && embedded {
+				if isPointer && !ast.IsExported(fieldName) {
+					g.errs = append(g.errs, fmt.Sprintf("%s : field cannot be set, it must be exported", fieldPath))
+					continue
+				}
+				nestedPrefix := prefix
+				if tagName != "" {
+					nestedPrefix = name + "."
+				}
+				g.walkNested(nested, isPointer, tagName != "", fieldExpr, nestedPrefix, fieldPath)
+				continue
+			}
+
+			if !ast.IsExported(fieldName) {
+				continue
+			}
+
+			// Nested structs of the package, with the name of the field as prefix, unless they
+			// are atomic values
+			if nested, isPointer, ok := g.nestedStruct(field.Type); ok {
+				if _, isAtomic := g.loads[nested.name]; !isAtomic {
+					g.walkNested(nested, isPointer, tagName != "", fieldExpr, name+".", fieldPath)
+					continue
+				}
+			}
+
+			// Functions, numeric fields and atomic values are exposed as gauges read at flush time
+			if gauge, ok := g.gauge(st.file, field.Type, fieldExpr); ok {
+				if err := metrics.RegisterMetric(metrics.NewTaggedRegistry(), name, gometrics.NewGauge(), tag); err != nil {
+					g.errs = append(g.errs, fmt.Sprintf("%s : %s", fieldPath, err))
+					continue
+				}
+				if !g.useName(name, fieldPath) {
+					continue
+				}
+				if _, isFunc := field.Type.(*ast.FuncType); isFunc {
+					// A nil function is not registered, as RegistryFromStruct rejects it
+					fmt.Fprintf(&g.registerBuf, "if %s != nil {\nmetrics.RegisterMetric(r, %q, %s, %q)\n}\n", fieldExpr, name, gauge, tag)
+					continue
+				}
+				fmt.Fprintf(&g.registerBuf, "metrics.RegisterMetric(r, %q, %s, %q)\n", name, gauge, tag)
+				continue
+			}
+
+			// The other fields are skipped unless they are metrics, as RegistryFromStruct does
+			metricType, goType, ok := g.metricType(st.file, field.Type)
+			if !ok {
+				continue
+			}
+
+			// The tags are checked by the functions used by RegistryFromStruct
+			i, err := metrics.NewMetric(metricType, tag)
+			if err == nil {
+				err = metrics.RegisterMetric(metrics.NewTaggedRegistry(), name, i, tag)
+			}
+			if err != nil {
+				g.errs = append(g.errs, fmt.Sprintf("%s : %s", fieldPath, err))
+				continue
+			}
+
+			if !g.useName(name, fieldPath) {
+				continue
+			}
+
+			// Families declared by value are registered through their address
+			if !strings.HasPrefix(goType, "*") && strings.HasSuffix(metricType, "Family") {
+				fmt.Fprintf(&g.registerBuf, "metrics.RegisterMetric(r, %q, &%s, %q)\n", name, fieldExpr, tag)
+				continue
+			}
+			fmt.Fprintf(&g.newBuf, "%s = metrics.MustNewMetric(%q, %q).(%s)\n", fieldExpr, metricType, tag, goType)
+			fmt.Fprintf(&g.registerBuf, "metrics.RegisterMetric(r, %q, %s, %q)\n", name, fieldExpr, tag)
+		}
+	}
+}
+
+// walkNested explores a nested or embedded struct, allocating it if it is a pointer. As with
+// metrics.RegistryFromStruct, a pointer to a struct type already being explored is left nil,
+// as is a struct holding no metric unless its field has a `metrics:""` tag.
+func (g *generator) walkNested(nested *structType, isPointer, tagged bool, expr, prefix, path string) {
+	if _, exists := g.visiting[nested.name]; exists {
+		return
+	}
+	newLen, registerLen := g.newBuf.Len(), g.registerBuf.Len()
+	if isPointer {
+		fmt.Fprintf(&g.newBuf, "%s = &%s{}\n", expr, nested.name)
+	}
+	g.walk(nested, expr, prefix, path)
+	if !tagged && g.registerBuf.Len() == registerLen {
+		g.newBuf.Truncate(newLen)
+	}
+}
+
+// useName reserves the name of a metric, reporting an error if it is already used
+func (g *generator) useName(name, fieldPath string) bool {
+	if other, exists := g.names[name]; exists {
+		g.errs = append(g.errs, fmt.Sprintf("%s : metric name %q already used by %s", fieldPath, name, other))
+		return false
+	}
+	g.names[name] = fieldPath
+	return true
+}
+
+// atomicLoads are the kinds returned by the Load method of the atomic types of other packages
+var atomicLoads = map[string]string{
+	"sync/atomic.Int64":          "int64",
+	"sync/atomic.Uint64":         "uint64",
+	"go.uber.org/atomic.Int64":   "int64",
+	"go.uber.org/atomic.Uint64":  "uint64",
+	"go.uber.org/atomic.Float64": "float64",
+}
+
+// gauge returns the expression of the functional gauge reading the field accessed through
+// expr, if its type is exposed as a gauge by metrics.RegistryFromStruct
+func (g *generator) gauge(file *ast.File, typ ast.Expr, expr string) (string, bool) {
+	switch t := typ.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "int64":
+			g.usesAtomic = true
+			return fmt.Sprintf("gometrics.NewFunctionalGauge(func() int64 { return atomic.LoadInt64(&%s) })", expr), true
+		case "uint64":
+			g.usesAtomic = true
+			return fmt.Sprintf("gometrics.NewFunctionalGauge(func() int64 { return int64(atomic.LoadUint64(&%s)) })", expr), true
+		}
+		if kind, exists := g.loads[t.Name]; exists {
+			return loadGauge(kind, expr), true
+		}
+
+	case *ast.SelectorExpr:
+		pkgIdent, ok := t.X.(*ast.Ident)
+		if !ok {
+			return "", false
+		}
+		if kind, exists := atomicLoads[importPath(file, pkgIdent.Name)+"."+t.Sel.Name]; exists {
+			return loadGauge(kind, expr), true
+		}
+
+	case *ast.FuncType:
+		switch funcResultKind(t) {
+		case "int64":
+			return fmt.Sprintf("gometrics.NewFunctionalGauge(%s)", expr), true
+		case "float64":
+			return fmt.Sprintf("gometrics.NewFunctionalGaugeFloat64(%s)", expr), true
+		}
+	}
+	return "", false
+}
+
+// loadGauge returns the expression of the functional gauge reading a value through its Load method
+func loadGauge(kind, expr string) string {
+	switch kind {
+	case "int64":
+		return fmt.Sprintf("gometrics.NewFunctionalGauge(func() int64 { return %s.Load() })", expr)
+	case "uint64":
+		return fmt.Sprintf("gometrics.NewFunctionalGauge(func() int64 { return int64(%s.Load()) })", expr)
+	}
+	return fmt.Sprintf("gometrics.NewFunctionalGaugeFloat64(func() float64 { return %s.Load() })", expr)
+}
+
+// funcResultKind returns the type returned by a function without parameters, if it is
+// int64, uint64 or float64
+func funcResultKind(t *ast.FuncType) string {
+	if t.Params != nil && len(t.Params.List) > 0 {
+		return ""
+	}
+	if t.Results == nil || len(t.Results.List) != 1 || len(t.Results.List[0].Names) > 1 {
+		return ""
+	}
+	ident, ok := t.Results.List[0].Type.(*ast.Ident)
+	if !ok {
+		return ""
+	}
+	switch ident.Name {
+	case "int64", "uint64", "float64":
+		return ident.Name
+	}
+	return ""
+}
+
+// nestedStruct returns the struct of the package the type refers to, if any
+func (g *generator) nestedStruct(expr ast.Expr) (*structType, bool, bool) {
+	isPointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		isPointer = true
+		expr = star.X
+	}
+
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return nil, false, false
+	}
+	st, exists := g.structs[ident.Name]
+	return st, isPointer, exists
+}
+
+// metricType returns the type of the metric as named by metrics.NewMetric, and the Go type of
+// the field in the generated code, if the field is a metric
+func (g *generator) metricType(file *ast.File, expr ast.Expr) (string, string, bool) {
+	isPointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		isPointer = true
+		expr = star.X
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return "", "", false
+	}
+	pkgIdent, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return "", "", false
+	}
+
+	switch importPath(file, pkgIdent.Name) {
+	case goMetricsPath:
+		if strings.HasSuffix(sel.Sel.Name, "Family") {
+			return "", "", false
+		}
+		return "metrics." + sel.Sel.Name, "gometrics." + sel.Sel.Name, !isPointer
+	case histogramPath:
+		g.usesHistogram = true
+		return "histogram." + sel.Sel.Name, "histogram." + sel.Sel.Name, !isPointer
+	case metricsPath:
+		if !strings.HasSuffix(sel.Sel.Name, "Family") {
+			return "", "", false
+		}
+		if isPointer {
+			return "metrics." + sel.Sel.Name, "*metrics." + sel.Sel.Name, true
+		}
+		return "metrics." + sel.Sel.Name, "metrics." + sel.Sel.Name, true
+	}
+	return "", "", false
+}
+
+// importPath returns the path of the package imported with the given name in the file
+func importPath(file *ast.File, name string) string {
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		importName := path[strings.LastIndex(path, "/")+1:]
+		if path == goMetricsPath {
+			importName = "metrics"
+		}
+		if spec.Name != nil {
+			importName = spec.Name.Name
+		}
+
+		if importName == name {
+			return path
+		}
+	}
+	return ""
+}
+
+// typeName returns the name of the type of an embedded field
+func typeName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return typeName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.Ident:
+		return t.Name
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func generateFromSource(t *testing.T, src string, types ...string) (string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := newGenerator("server", []*ast.File{file}).generate(types)
+	return string(out), err
+}
+
+func TestGenerate(t *testing.T) {
+	src := `package server
+
+import (
+	gometrics "github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+type base struct {
+	Requests gometrics.Counter
+}
+
+type DBMetrics struct {
+	Queries gometrics.Counter
+}
+
+type Options struct {
+	Name string
+}
+
+type ServerMetrics struct {
+	base
+	DB      *DBMetrics ` + "`metrics:\"db\"`" + `
+	Latency gometrics.Histogram ` + "`metrics_sample:\"exp\" metrics_sample_value:\"1028-0.015\"`" + `
+	Sizes   histogram.Bucketed ` + "`metrics_buckets:\"linear\" metrics_buckets_value:\"0,10,5\"`" + `
+	Calls   gometrics.Timer ` + "`metrics_reset:\"flush\" metrics_help:\"duration of the calls\"`" + `
+	Status  *metrics.CounterFamily ` + "`metrics_label:\"status\"`" + `
+	Methods metrics.MeterFamily ` + "`metrics_label:\"method\"`" + `
+	Options *Options
+	Name    string
+	hidden  int
+}
+`
+
+	out, err := generateFromSource(t, src, "ServerMetrics")
+	if err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+
+	for _, expected := range []string{
+		"func NewServerMetrics() *ServerMetrics {",
+		`m.base.Requests = metrics.MustNewMetric("metrics.Counter", "").(gometrics.Counter)`,
+		"m.DB = &DBMetrics{}",
+		`m.DB.Queries = metrics.MustNewMetric("metrics.Counter", "").(gometrics.Counter)`,
+		`m.Latency = metrics.MustNewMetric("metrics.Histogram", "metrics_sample:\"exp\" metrics_sample_value:\"1028-0.015\"").(gometrics.Histogram)`,
+		`m.Sizes = metrics.MustNewMetric("histogram.Bucketed", "metrics_buckets:\"linear\" metrics_buckets_value:\"0,10,5\"").(histogram.Bucketed)`,
+		`m.Status = metrics.MustNewMetric("metrics.CounterFamily", "metrics_label:\"status\"").(*metrics.CounterFamily)`,
+		"r := metrics.NewTaggedRegistry()",
+		`metrics.RegisterMetric(r, "requests", m.base.Requests, "")`,
+		`metrics.RegisterMetric(r, "db.queries", m.DB.Queries, "")`,
+		`metrics.RegisterMetric(r, "calls", m.Calls, "metrics_reset:\"flush\" metrics_help:\"duration of the calls\"")`,
+		`metrics.RegisterMetric(r, "methods", &m.Methods, "metrics_label:\"method\"")`,
+		"func (m *ServerMetrics) Register(name string, tags map[string]string) {",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the generated code :\n%s", expected, out)
+		}
+	}
+	// As with RegistryFromStruct, the fields which are not metrics are skipped
+	if strings.Contains(out, "m.Options") || strings.Contains(out, "m.Name") {
+		t.Errorf("unexpected field without metric in the generated code :\n%s", out)
+	}
+
+	buildGenerated(t, src, out)
+}
+
+func TestGenerateErrors(t *testing.T) {
+	src := `package server
+
+import (
+	"github.com/rcrowley/go-metrics"
+	root "github.com/ybriffa/metrics"
+)
+
+type Node struct {
+	Next *Node
+}
+
+type hidden struct {
+	X metrics.Counter
+}
+
+type ServerMetrics struct {
+	*hidden
+	Toto    metrics.Counter ` + "`metrics:\"same\"`" + `
+	Titi    metrics.Counter ` + "`metrics:\"same\"`" + `
+	Latency metrics.Histogram ` + "`metrics_sample:\"unknown\"`" + `
+	Name    string ` + "`metrics_help:\"not a metric\"`" + `
+	Labeled *root.CounterFamily
+	Meter   metrics.Meter ` + "`metrics_reset:\"never\"`" + `
+	Tagged  metrics.Counter ` + "`metrics_tags:\"invalid\"`" + `
+	Node    Node
+}
+`
+
+	_, err := generateFromSource(t, src, "ServerMetrics", "Missing")
+	if err == nil {
+		t.Fatal("error expected")
+	}
+
+	expected := []string{
+		"ServerMetrics.hidden : field cannot be set, it must be exported",
+		`ServerMetrics.Titi : metric name "same" already used by ServerMetrics.Toto`,
+		"ServerMetrics.Latency : unknown sample type",
+		"ServerMetrics.Labeled : missing metrics_label tag on family",
+		"ServerMetrics.Meter : unknown reset mode",
+		"ServerMetrics.Tagged : invalid metrics tags format",
+		"Missing : struct type not found",
+	}
+	if err.Error() != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected error :\n%s", err)
+	}
+}
+
+func TestGenerateGauges(t *testing.T) {
+	src := `package server
+
+import (
+	"sync/atomic"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+type loadCounter struct {
+	v int64
+}
+
+func (c *loadCounter) Load() int64 { return atomic.LoadInt64(&c.v) }
+
+type inner struct {
+	X metrics.Counter
+}
+
+type Node struct {
+	Value metrics.Gauge
+	Next  *Node
+}
+
+type ServerMetrics struct {
+	Sent    int64 ` + "`metrics_help:\"messages sent\"`" + `
+	Dropped uint64
+	Queue   func() int64
+	Ratio   func() float64
+	Loaded  loadCounter
+	Atomic  atomic.Int64
+	Node    Node
+	hidden  inner
+}
+`
+
+	out, err := generateFromSource(t, src, "ServerMetrics")
+	if err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+
+	for _, expected := range []string{
+		`metrics.RegisterMetric(r, "sent", gometrics.NewFunctionalGauge(func() int64 { return atomic.LoadInt64(&m.Sent) }), "metrics_help:\"messages sent\"")`,
+		`metrics.RegisterMetric(r, "dropped", gometrics.NewFunctionalGauge(func() int64 { return int64(atomic.LoadUint64(&m.Dropped)) }), "")`,
+		`metrics.RegisterMetric(r, "queue", gometrics.NewFunctionalGauge(m.Queue), "")`,
+		`metrics.RegisterMetric(r, "ratio", gometrics.NewFunctionalGaugeFloat64(m.Ratio), "")`,
+		`metrics.RegisterMetric(r, "loaded", gometrics.NewFunctionalGauge(func() int64 { return m.Loaded.Load() }), "")`,
+		`metrics.RegisterMetric(r, "atomic", gometrics.NewFunctionalGauge(func() int64 { return m.Atomic.Load() }), "")`,
+		`metrics.RegisterMetric(r, "node.value", m.Node.Value, "")`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in the generated code :\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "hidden") || strings.Contains(out, "m.Node.Next") {
+		t.Errorf("unexpected unexported or recursive field in the generated code :\n%s", out)
+	}
+
+	buildGenerated(t, src, out)
+}
+
+// buildGenerated builds a package made of the source and the code generated from it
+func buildGenerated(t *testing.T, src, generated string) {
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	// The package must be in the module to resolve its imports
+	if err := os.MkdirAll("testdata", 0755); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("testdata", "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range map[string]string{"server.go": src, "server_metrics.go": generated} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goBin, "build", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code does not build : %s\n%s", err, out)
+	}
+}
@@ -0,0 +1,88 @@
+// metricsgen generates the code instantiating and registering the metrics of structs
+// annotated with the tags of metrics.RegistryFromStruct, so their errors are detected
+// at generation time rather than at runtime. It is meant to be used with go generate :
+//
+//	//go:generate metricsgen -type=ServerMetrics
+//
+// For each type, it generates in the file <type>_metrics.go :
+//   - NewServerMetrics() *ServerMetrics, instantiating all the metrics
+//   - (*ServerMetrics).Registry() metrics.Registry, registering them with the names
+//     metrics.RegistryFromStruct would give them
+//   - (*ServerMetrics).Register(name, tags), registering the registry to be sent by the drivers
+//
+// The tags are parsed by metrics.NewMetric and metrics.RegisterMetric, as by RegistryFromStruct,
+// and the fields which are not metrics are skipped the same way. Every invalid tag and name
+// collision is reported and nothing is generated.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	typeNames := flag.String("type", "", "comma-separated list of the struct types to generate the code for")
+	output := flag.String("output", "", "output file name, default <type>_metrics.go")
+	dir := flag.String("dir", ".", "directory of the package declaring the types")
+	flag.Parse()
+
+	if *typeNames == "" {
+		fmt.Fprintln(os.Stderr, "metricsgen: -type is mandatory")
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	if *output == "" {
+		*output = strings.ToLower(types[0]) + "_metrics.go"
+	}
+	*output = filepath.Join(*dir, *output)
+
+	if err := run(*dir, types, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "metricsgen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, types []string, output string) error {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && filepath.Join(dir, fi.Name()) != output
+	}, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	for name, pkg := range pkgs {
+		// Sort the files to generate the same code whatever the order of the directory
+		var fileNames []string
+		for fileName := range pkg.Files {
+			fileNames = append(fileNames, fileName)
+		}
+		sort.Strings(fileNames)
+
+		var files []*ast.File
+		for _, fileName := range fileNames {
+			files = append(files, pkg.Files[fileName])
+		}
+
+		src, err := newGenerator(name, files).generate(types)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(output, src, 0644)
+	}
+
+	return nil
+}
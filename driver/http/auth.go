@@ -0,0 +1,238 @@
+package http
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	treemux "github.com/dimfeld/httptreemux/v5"
+)
+
+// authConfig protects the routes of the driver. The rule applies to all the routes, unless
+// the route has its own rule in Routes, keyed by its pattern such as `/section/:name`.
+type authConfig struct {
+	accessRule
+	Routes map[string]*accessRule `json:"routes"`
+}
+
+// accessRule defines who can access a route. The requests must come from one of the allowed
+// IPs if any, and present either valid basic auth credentials or a valid bearer token if any
+// is configured.
+type accessRule struct {
+	// Public disables any check
+	Public bool `json:"public"`
+	// Basic are the passwords of the users allowed with the basic auth
+	Basic map[string]string `json:"basic"`
+	// BearerTokens are the tokens allowed
+	BearerTokens []string `json:"bearer_tokens"`
+	// BearerTokenFile is a file holding the tokens allowed, one per line, read again when it changes
+	BearerTokenFile string `json:"bearer_token_file"`
+	// AllowedIPs are the IPs or networks, such as 10.0.0.0/8, allowed
+	AllowedIPs []string `json:"allowed_ips"`
+
+	networks  []*net.IPNet
+	tokenFile *tokenFile
+}
+
+// init validates the configuration and prepares the rules
+func (c *authConfig) init() error {
+	if err := c.accessRule.init(); err != nil {
+		return err
+	}
+	for pattern, rule := range c.Routes {
+		if err := rule.init(); err != nil {
+			return fmt.Errorf("route %s : %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// rule returns the rule of the route
+func (c *authConfig) rule(pattern string) *accessRule {
+	if rule, exists := c.Routes[pattern]; exists {
+		return rule
+	}
+	return &c.accessRule
+}
+
+func (ar *accessRule) init() error {
+	for _, raw := range ar.AllowedIPs {
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return fmt.Errorf("invalid allowed ip %s", raw)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			ar.networks = append(ar.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(raw)
+		if err != nil {
+			return fmt.Errorf("invalid allowed network %s : %s", raw, err)
+		}
+		ar.networks = append(ar.networks, network)
+	}
+
+	if ar.BearerTokenFile != "" {
+		ar.tokenFile = &tokenFile{path: ar.BearerTokenFile}
+		if _, err := ar.tokenFile.get(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// check returns the status of the response when the request is not allowed, 0 otherwise
+func (ar *accessRule) check(r *http.Request) int {
+	if ar.Public {
+		return 0
+	}
+
+	if len(ar.networks) > 0 && !ar.allowedIP(r.RemoteAddr) {
+		return http.StatusForbidden
+	}
+
+	if len(ar.Basic) == 0 && len(ar.BearerTokens) == 0 && ar.tokenFile == nil {
+		return 0
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		expected, exists := ar.Basic[user]
+		if exists && secureCompare(password, expected) {
+			return 0
+		}
+		return http.StatusUnauthorized
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") && ar.validToken(strings.TrimPrefix(authorization, "Bearer ")) {
+		return 0
+	}
+
+	return http.StatusUnauthorized
+}
+
+func (ar *accessRule) allowedIP(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range ar.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ar *accessRule) validToken(token string) bool {
+	tokens := ar.BearerTokens
+	if ar.tokenFile != nil {
+		fileTokens, err := ar.tokenFile.get()
+		if err == nil {
+			tokens = append(tokens[:len(tokens):len(tokens)], fileTokens...)
+		}
+	}
+
+	valid := false
+	for _, expected := range tokens {
+		if secureCompare(token, expected) {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// challenge returns the WWW-Authenticate header of the unauthorized responses
+func (ar *accessRule) challenge() string {
+	if len(ar.Basic) > 0 {
+		return `Basic realm="metrics"`
+	}
+	return `Bearer realm="metrics"`
+}
+
+func secureCompare(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// tokenFile holds the tokens of a file, read again when its modification time changes
+type tokenFile struct {
+	path string
+
+	m       sync.Mutex
+	modTime time.Time
+	tokens  []string
+}
+
+func (tf *tokenFile) get() ([]string, error) {
+	tf.m.Lock()
+	defer tf.m.Unlock()
+
+	info, err := os.Stat(tf.path)
+	if err != nil {
+		return nil, err
+	}
+	if tf.tokens != nil && info.ModTime().Equal(tf.modTime) {
+		return tf.tokens, nil
+	}
+
+	f, err := os.Open(tf.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	tokens := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if token := strings.TrimSpace(scanner.Text()); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	tf.tokens, tf.modTime = tokens, info.ModTime()
+	return tokens, nil
+}
+
+// authorize wraps the handler of the route to check the requests against its access rule
+func (hd *Driver) authorize(pattern string, h treemux.HandlerFunc) treemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, args map[string]string) {
+		hd.m.RLock()
+		auth := hd.auth
+		hd.m.RUnlock()
+
+		if auth != nil {
+			rule := auth.rule(pattern)
+			switch rule.check(r) {
+			case http.StatusForbidden:
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			case http.StatusUnauthorized:
+				w.Header().Set("WWW-Authenticate", rule.challenge())
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		h(w, r, args)
+	}
+}
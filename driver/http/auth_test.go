@@ -0,0 +1,104 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAuthorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenPath := filepath.Join(dir, "tokens")
+	if err := ioutil.WriteFile(tokenPath, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := &authConfig{
+		accessRule: accessRule{
+			Basic:           map[string]string{"user": "password"},
+			BearerTokens:    []string{"static-token"},
+			BearerTokenFile: tokenPath,
+		},
+		Routes: map[string]*accessRule{
+			"/status":        {Public: true},
+			"/section/:name": {AllowedIPs: []string{"10.0.0.0/8", "192.168.1.1"}},
+		},
+	}
+	if err := auth.init(); err != nil {
+		t.Fatal(err)
+	}
+	hd := &Driver{auth: auth}
+
+	for i, test := range []struct {
+		pattern       string
+		remoteAddr    string
+		user          string
+		password      string
+		authorization string
+		expected      int
+	}{
+		//0 no credentials
+		{"/sections/metrics", "127.0.0.1:1234", "", "", "", http.StatusUnauthorized},
+		//1 valid basic auth
+		{"/sections/metrics", "127.0.0.1:1234", "user", "password", "", http.StatusOK},
+		//2 invalid password
+		{"/sections/metrics", "127.0.0.1:1234", "user", "wrong", "", http.StatusUnauthorized},
+		//3 static token
+		{"/sections/metrics", "127.0.0.1:1234", "", "", "Bearer static-token", http.StatusOK},
+		//4 token from the file
+		{"/sections/metrics", "127.0.0.1:1234", "", "", "Bearer file-token", http.StatusOK},
+		//5 invalid token
+		{"/sections/metrics", "127.0.0.1:1234", "", "", "Bearer other", http.StatusUnauthorized},
+		//6 public route
+		{"/status", "127.0.0.1:1234", "", "", "", http.StatusOK},
+		//7 allowed network
+		{"/section/:name", "10.1.2.3:1234", "", "", "", http.StatusOK},
+		//8 allowed ip
+		{"/section/:name", "192.168.1.1:1234", "", "", "", http.StatusOK},
+		//9 ip not allowed
+		{"/section/:name", "192.168.1.2:1234", "", "", "", http.StatusForbidden},
+	} {
+		h := hd.authorize(test.pattern, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		if test.user != "" {
+			req.SetBasicAuth(test.user, test.password)
+		}
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+
+		w := httptest.NewRecorder()
+		h(w, req, nil)
+		if w.Code != test.expected {
+			t.Fatalf("test #%d failed : expected status %d and got %d", i, test.expected, w.Code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("test #%d failed : no WWW-Authenticate header", i)
+		}
+	}
+
+	// The token file is read again when it changes
+	if err := ioutil.WriteFile(tokenPath, []byte("new-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenPath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if !auth.validToken("new-token") || auth.validToken("file-token") {
+		t.Fatal("token file not reloaded")
+	}
+
+	if err := (&accessRule{AllowedIPs: []string{"invalid"}}).init(); err == nil {
+		t.Fatal("expected an error on an invalid ip")
+	}
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedResponses bounds the number of responses cached, as the filters of the
+// queries can lead to any number of different responses
+const maxCachedResponses = 128
+
+// responseCache holds the responses encoded since the last Send, so the frequent scrapers
+// do not encode the same metrics again and again
+type responseCache struct {
+	m       sync.Mutex
+	entries map[string]*cachedResponse
+	// generation is incremented at each reset, so a response built from the metrics of
+	// a previous Send is not cached
+	generation uint64
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: map[string]*cachedResponse{}}
+}
+
+// get returns the response cached for the key, and the current generation of the cache
+func (c *responseCache) get(key string) (*cachedResponse, uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.entries[key], c.generation
+}
+
+// set caches the response built during the generation given, unless the cache was reset since
+func (c *responseCache) set(key string, resp *cachedResponse, generation uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, exists := c.entries[key]; generation != c.generation || (!exists && len(c.entries) >= maxCachedResponses) {
+		return
+	}
+	c.entries[key] = resp
+}
+
+// reset drops all the responses cached
+func (c *responseCache) reset() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.entries = map[string]*cachedResponse{}
+	c.generation++
+}
+
+// cachedResponse is an encoded response, with its compressed versions built on demand
+type cachedResponse struct {
+	body        []byte
+	contentType string
+	// hash identifies the body, to build the ETag of each content encoding
+	hash string
+	// built is the time the response was built, to expire it in live mode
+	built time.Time
+
+	m       sync.Mutex
+	encoded map[string][]byte
+}
+
+func newCachedResponse(body []byte, contentType string) *cachedResponse {
+	h := fnv.New64a()
+	h.Write(body)
+
+	return &cachedResponse{
+		body:        body,
+		contentType: contentType,
+		hash:        fmt.Sprintf("%x", h.Sum64()),
+		built:       time.Now(),
+		encoded:     map[string][]byte{},
+	}
+}
+
+// etag returns the ETag of the response with the content encoding given, which differs for
+// each encoding as the bytes sent differ
+func (c *cachedResponse) etag(encoding string) string {
+	if encoding == "" {
+		return `"` + c.hash + `"`
+	}
+	return `"` + c.hash + "-" + encoding + `"`
+}
+
+// encode returns the body compressed with the content encoding given
+func (c *cachedResponse) encode(encoding string) ([]byte, error) {
+	if encoding == "" {
+		return c.body, nil
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if encoded, exists := c.encoded[encoding]; exists {
+		return encoded, nil
+	}
+
+	encoded, err := compress(encoding, c.body)
+	if err != nil {
+		return nil, err
+	}
+	c.encoded[encoding] = encoded
+	return encoded, nil
+}
+
+// etagMatches tells whether the If-None-Match header given matches the ETag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for i, test := range []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		//0 no header
+		{"", ""},
+		//1 unhandled encoding
+		{"br", ""},
+		//2 gzip
+		{"gzip, deflate", encodingGzip},
+		//3 zstd preferred at the same quality
+		{"gzip, zstd", encodingZstd},
+		//4 quality
+		{"zstd;q=0.5, gzip", encodingGzip},
+		//5 refused encoding
+		{"gzip;q=0", ""},
+	} {
+		if encoding := negotiateEncoding(test.acceptEncoding); encoding != test.expected {
+			t.Fatalf("test #%d failed : expected '%s' and got '%s'", i, test.expected, encoding)
+		}
+	}
+}
+
+func TestWriteCached(t *testing.T) {
+	// The responses are only cached in snapshot mode
+	hd, err := New(Options{Name: "app", Mode: ModeSnapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	registries := []*driver.Registry{{Name: "reg", Registry: r}}
+	hd.Send(registries)
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/sections/metrics", nil)
+		req.Header = header
+		req.Header.Set("Accept", "text/plain; version=0.0.4")
+		w := httptest.NewRecorder()
+		hd.expandSections(w, req, nil)
+		return w
+	}
+
+	plain := get(http.Header{})
+	if !bytes.Contains(plain.Body.Bytes(), []byte("app_reg_requests_total 0")) {
+		t.Fatalf("unexpected body %s", plain.Body.String())
+	}
+
+	// The compressed responses hold the same metrics
+	gz := get(http.Header{"Accept-Encoding": []string{"gzip"}})
+	if gz.Header().Get("Content-Encoding") != "gzip" || gz.Header().Get("ETag") == plain.Header().Get("ETag") {
+		t.Fatalf("unexpected headers %v", gz.Header())
+	}
+	gzReader, err := gzip.NewReader(gz.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(gzReader); !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Fatalf("unexpected gzip body %s", body)
+	}
+
+	zst := get(http.Header{"Accept-Encoding": []string{"zstd"}})
+	decoder, _ := zstd.NewReader(nil)
+	if body, err := decoder.DecodeAll(zst.Body.Bytes(), nil); err != nil || !bytes.Equal(body, plain.Body.Bytes()) {
+		t.Fatalf("unexpected zstd body %s : %v", body, err)
+	}
+
+	// The response is not sent again to a client having it
+	notModified := get(http.Header{"If-None-Match": []string{plain.Header().Get("ETag")}})
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Fatalf("expected a 304 and got %d", notModified.Code)
+	}
+
+	// The response is cached until the next Send
+	c.Inc(1)
+	if cached := get(http.Header{}); !bytes.Equal(cached.Body.Bytes(), plain.Body.Bytes()) {
+		t.Fatalf("expected the cached body and got %s", cached.Body.String())
+	}
+	hd.Send(registries)
+	updated := get(http.Header{"If-None-Match": []string{plain.Header().Get("ETag")}})
+	if updated.Code != http.StatusOK || !bytes.Contains(updated.Body.Bytes(), []byte("app_reg_requests_total 1")) {
+		t.Fatalf("unexpected response %d %s", updated.Code, updated.Body.String())
+	}
+}
+
+func TestWriteCachedLive(t *testing.T) {
+	// In live mode, the responses are cached during the cache TTL
+	hd, err := New(Options{Name: "app", CacheTTL: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/sections/metrics", nil)
+		req.Header.Set("Accept", "text/plain; version=0.0.4")
+		w := httptest.NewRecorder()
+		hd.expandSections(w, req, nil)
+		return w
+	}
+
+	first := get()
+	c.Inc(1)
+	if cached := get(); !bytes.Equal(cached.Body.Bytes(), first.Body.Bytes()) {
+		t.Fatalf("expected the cached body and got %s", cached.Body.String())
+	}
+
+	// Once expired, the response is built again from the current values
+	hd.m.Lock()
+	hd.cacheTTL = time.Nanosecond
+	hd.m.Unlock()
+	if updated := get(); !bytes.Contains(updated.Body.Bytes(), []byte("app_reg_requests_total 1")) {
+		t.Fatalf("expected the current value and got %s", updated.Body.String())
+	}
+
+	if _, err := New(Options{Name: "app", CacheTTL: -time.Second}); err != ErrInvalidCacheTTL {
+		t.Fatalf("expected error `%s` and got `%v`", ErrInvalidCacheTTL, err)
+	}
+}
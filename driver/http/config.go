@@ -0,0 +1,59 @@
+package http
+
+import (
+	"encoding/json"
+
+	"github.com/ovh/configstore"
+)
+
+const (
+	configStoreAlias = "http-metrics"
+)
+
+// config is the configuration of the http driver, read from the configstore item http-metrics.
+// The driver is enabled without protection nor listener when the item does not exist.
+type config struct {
+	Auth *authConfig `json:"auth"`
+
+	// Listen is the address of the server started by the driver, if any
+	Listen string `json:"listen"`
+	// TLSCert and TLSKey are the files of the certificate and the key to listen in TLS
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Prefix is the path under which the routes are exposed by the server, such as /metrics
+	Prefix string `json:"prefix"`
+
+	// HistorySize is the number of flushes kept in the history of each section
+	HistorySize int `json:"history_size"`
+
+	// Mode is either live, to serve the values of the metrics at the time of the requests, or
+	// snapshot, to serve the values of the last flush. Live by default.
+	Mode string `json:"mode"`
+	// CacheTTL is the duration, such as 1s, the responses are cached in live mode. They are
+	// built at each request by default.
+	CacheTTL string `json:"cache_ttl"`
+}
+
+// loadConfig reads and validates the configuration from the configstore item given
+func loadConfig(alias string) (*config, error) {
+	var c config
+
+	rawConfig, err := configstore.GetItemValue(alias)
+	if err != nil {
+		if _, ok := err.(configstore.ErrItemNotFound); !ok {
+			return nil, err
+		}
+		return &c, nil
+	}
+
+	if err := json.Unmarshal([]byte(rawConfig), &c); err != nil {
+		return nil, err
+	}
+
+	if c.Auth != nil {
+		if err := c.Auth.init(); err != nil {
+			return nil, err
+		}
+	}
+	return &c, nil
+}
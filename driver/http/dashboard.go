@@ -0,0 +1,208 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Size of the sparklines, in pixels
+const (
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+// dashboardTemplate is the page of the dashboard, without any external asset
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}} metrics</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-bottom: 0.2em; }
+.tags { color: #666; margin-bottom: 0.5em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; vertical-align: middle; }
+th { border-bottom: 1px solid #ccc; }
+.values { font-family: monospace; font-size: 0.9em; }
+polyline { fill: none; stroke: #2a6db5; stroke-width: 1.5; }
+</style>
+</head>
+<body>
+<h1>{{.Name}} metrics</h1>
+<p>Updated at {{.Updated.Format "2006-01-02 15:04:05 MST"}}</p>
+{{range .Sections}}
+<h2>{{.ID}}</h2>
+<div class="tags">{{range .Tags}}{{.}} {{else}}no tags{{end}}</div>
+<table>
+<tr><th>metric</th><th>values</th><th>recent</th></tr>
+{{range .Metrics}}<tr>
+<td>{{.Name}}</td>
+<td class="values">{{.Values}}</td>
+<td>{{if .Sparkline}}<svg width="` + fmt.Sprint(sparklineWidth) + `" height="` + fmt.Sprint(sparklineHeight) + `"><polyline points="{{.Sparkline}}"/></svg>{{end}}</td>
+</tr>
+{{end}}</table>
+{{else}}
+<p>No metrics sent yet.</p>
+{{end}}
+</body>
+</html>
+`))
+
+type dashboard struct {
+	Name     string
+	Updated  time.Time
+	Sections []*dashboardSection
+}
+
+type dashboardSection struct {
+	ID      string
+	Tags    []string
+	Metrics []*dashboardMetric
+}
+
+type dashboardMetric struct {
+	Name      string
+	Values    string
+	Sparkline string
+}
+
+// showDashboard renders an HTML page with the current values of the metrics of all
+// the sections, and sparklines of their values at the last flushes
+func (hd *Driver) showDashboard(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	hd.m.RLock()
+	name := hd.name
+	hd.m.RUnlock()
+
+	hd.writeCached(w, r, "text/html; charset=utf-8", func() ([]byte, error) {
+		d := &dashboard{Name: name, Updated: time.Now()}
+		hd.rangeSections(nil, func(id string, s *section) bool {
+			d.Sections = append(d.Sections, newDashboardSection(id, s))
+			return true
+		})
+		sort.Slice(d.Sections, func(i, j int) bool {
+			return d.Sections[i].ID < d.Sections[j].ID
+		})
+
+		var b bytes.Buffer
+		if err := dashboardTemplate.Execute(&b, d); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	})
+}
+
+func newDashboardSection(id string, s *section) *dashboardSection {
+	ds := &dashboardSection{ID: id}
+	for k, v := range s.tags {
+		ds.Tags = append(ds.Tags, k+"="+v)
+	}
+	sort.Strings(ds.Tags)
+
+	m, err := s.getMetrics(nil)
+	if err != nil {
+		return ds
+	}
+
+	// The values are the current ones, the history of the flushes only draws the sparklines
+	snapshots := s.history.since(time.Time{})
+	for name, values := range m.(map[string]map[string]interface{}) {
+		var points []float64
+		for _, snapshot := range snapshots {
+			if v, ok := primaryValue(snapshot.Values[name]); ok {
+				points = append(points, v)
+			}
+		}
+		ds.Metrics = append(ds.Metrics, &dashboardMetric{
+			Name:      name,
+			Values:    formatValues(values),
+			Sparkline: sparkline(points),
+		})
+	}
+	sort.Slice(ds.Metrics, func(i, j int) bool {
+		return ds.Metrics[i].Name < ds.Metrics[j].Name
+	})
+
+	return ds
+}
+
+// primaryValue returns the value drawn in the sparkline of a metric : the mean of the
+// histograms and timers, the one minute rate of the meters, the value of the gauges,
+// the count of the counters, and 1 or 0 for the healthchecks
+func primaryValue(values map[string]interface{}) (float64, bool) {
+	for _, key := range []string{"mean", "1m.rate", "value", "count"} {
+		if v, exists := values[key]; exists {
+			return toFloat(v)
+		}
+	}
+	if err, exists := values["error"]; exists {
+		if err == nil {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+// formatValues returns the values of a metric as key=value pairs sorted by key
+func formatValues(values map[string]interface{}) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		v := values[k]
+		if f, ok := v.(float64); ok {
+			v = formatFloat(f)
+		}
+		pairs[i] = fmt.Sprintf("%s=%v", k, v)
+	}
+	return strings.Join(pairs, " ")
+}
+
+// sparkline returns the points of the SVG polyline drawing the values, empty
+// if there are not enough values to draw a line
+func sparkline(values []float64) string {
+	if len(values) < 2 {
+		return ""
+	}
+
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	points := make([]string, len(values))
+	for i, v := range values {
+		x := float64(i) * sparklineWidth / float64(len(values)-1)
+		y := float64(sparklineHeight) / 2
+		if max > min {
+			y = sparklineHeight - (v-min)/(max-min)*sparklineHeight
+		}
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
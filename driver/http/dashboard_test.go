@@ -0,0 +1,70 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestDashboard(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	registries := []*driver.Registry{{Name: "reg", Registry: r, Tags: map[string]string{"env": "<prod>"}}}
+	for i := 0; i < 3; i++ {
+		c.Inc(int64(i))
+		hd.Send(registries)
+	}
+	// The values shown are the current ones, the sparklines the ones of the flushes
+	c.Inc(4)
+
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	body := w.Body.String()
+	for _, expected := range []string{
+		"<h2>reg(env:&lt;prod&gt;)</h2>",
+		"env=&lt;prod&gt;",
+		"<td>requests</td>",
+		"count=7",
+		`<polyline points="0.0,24.0 60.0,16.0 120.0,0.0"/>`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Fatalf("expected %s in the dashboard :\n%s", expected, body)
+		}
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	c.Inc(1)
+	w = httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(w.Body.String(), "count=8") {
+		t.Fatalf("expected the dashboard to follow the current values :\n%s", w.Body.String())
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	for i, test := range []struct {
+		values   []float64
+		expected string
+	}{
+		//0 not enough values
+		{[]float64{1}, ""},
+		//1 constant values
+		{[]float64{2, 2}, "0.0,12.0 120.0,12.0"},
+		//2 increasing values
+		{[]float64{0, 5, 10}, "0.0,24.0 60.0,12.0 120.0,0.0"},
+	} {
+		if points := sparkline(test.values); points != test.expected {
+			t.Fatalf("test #%d failed : unexpected points %s", i, points)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings handled, by order of preference for the same quality
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+// zstdEncoder is shared by all the responses, as EncodeAll can be called concurrently
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+// negotiateEncoding returns the content encoding with the highest quality in the
+// Accept-Encoding header, or an empty string to send the response uncompressed
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, raw := range strings.Split(acceptEncoding, ",") {
+		parts := strings.Split(raw, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding != encodingZstd && coding != encodingGzip {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err != nil {
+					q = 0
+				}
+			}
+		}
+
+		if q > bestQ || (q == bestQ && q > 0 && coding == encodingZstd) {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compress returns the body compressed with the content encoding given
+func compress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case encodingZstd:
+		return zstdEncoder.EncodeAll(body, make([]byte, 0, len(body)/2)), nil
+
+	case encodingGzip:
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		if _, err := gz.Write(body); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unknown content encoding %s", encoding)
+}
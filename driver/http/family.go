@@ -0,0 +1,281 @@
+package http
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+// Types of the metric families
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeSummary   = "summary"
+	typeHistogram = "histogram"
+)
+
+var quantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+// family is a group of samples sharing a name, a type and a help, as defined by Prometheus
+type family struct {
+	Name    string
+	Type    string
+	Help    string
+	Unit    string
+	Samples []*sample
+}
+
+// sample is a value of a family. Its name is the name of the family with a suffix,
+// such as _total for the counters or _bucket and _count for the histograms.
+type sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+
+	// group identifies the metric the sample comes from, to keep its samples together
+	group string
+}
+
+// familiesFromMetric returns the families describing the metric, with the labels given. The counters,
+// summaries and histograms get a _created sample holding the created time, unless it is zero.
+func familiesFromMetric(name string, i interface{}, md driver.Metadata, created time.Time) ([]*family, error) {
+	labels := sanitizeLabels(md.Tags)
+	f := &familyBuilder{name: name, labels: labels, group: labelsSignature(labels), md: md, created: created}
+
+	switch metric := i.(type) {
+
+	case metrics.Counter:
+		f.addCounter(metric.Count())
+
+	case metrics.Gauge:
+		f.add(name, typeGauge, float64(metric.Value()))
+
+	case metrics.GaugeFloat64:
+		f.add(name, typeGauge, metric.Value())
+
+	case metrics.Histogram:
+		h := metric.Snapshot()
+		if b, ok := h.(histogram.Bucketed); ok {
+			hist := f.newFamily(name, typeHistogram)
+			counts := b.BucketCounts()
+			for idx, bound := range b.Buckets() {
+				f.addSample(hist, name+"_bucket", "le", formatFloat(bound), float64(counts[idx]))
+			}
+			f.addSample(hist, name+"_bucket", "le", "+Inf", float64(b.Count()))
+			f.addSample(hist, name+"_sum", "", "", float64(b.Sum()))
+			f.addSample(hist, name+"_count", "", "", float64(b.Count()))
+			f.addCreated(hist)
+			break
+		}
+
+		f.addSummary(h.Percentiles(quantiles), float64(h.Sum()), h.Count())
+		f.add(name+"_min", typeGauge, float64(h.Min()))
+		f.add(name+"_max", typeGauge, float64(h.Max()))
+		f.add(name+"_mean", typeGauge, h.Mean())
+		f.add(name+"_std_dev", typeGauge, h.StdDev())
+
+	case metrics.Meter:
+		meter := metric.Snapshot()
+		f.addCounter(meter.Count())
+		f.add(name+"_one_minute", typeGauge, meter.Rate1())
+		f.add(name+"_five_minute", typeGauge, meter.Rate5())
+		f.add(name+"_fifteen_minute", typeGauge, meter.Rate15())
+		f.add(name+"_mean_rate", typeGauge, meter.RateMean())
+
+	case metrics.Timer:
+		// The durations are exposed in seconds, the base unit of Prometheus, as in the JSON
+		t := metric.Snapshot()
+		f.md.Unit = "seconds"
+		ps := t.Percentiles(quantiles)
+		for idx := range ps {
+			ps[idx] /= float64(time.Second)
+		}
+		f.addSummary(ps, float64(t.Sum())/float64(time.Second), t.Count())
+		f.addSeconds(name+"_min", float64(t.Min()))
+		f.addSeconds(name+"_max", float64(t.Max()))
+		f.addSeconds(name+"_mean", t.Mean())
+		f.addSeconds(name+"_std_dev", t.StdDev())
+		f.add(name+"_one_minute", typeGauge, t.Rate1())
+		f.add(name+"_five_minute", typeGauge, t.Rate5())
+		f.add(name+"_fifteen_minute", typeGauge, t.Rate15())
+		f.add(name+"_mean_rate", typeGauge, t.RateMean())
+
+	case metrics.Healthcheck:
+		healthy := f.newFamily(name+"_healthy", typeGauge)
+		healthy.Help = md.Help
+		f.addSample(healthy, healthy.Name, "", "", healthValue(metric))
+
+	default:
+		return nil, fmt.Errorf("Unknown metric type %T for metric '%s'", i, name)
+	}
+
+	return f.families, nil
+}
+
+// healthValue returns 1 if the healthcheck is healthy, 0 otherwise
+func healthValue(hc metrics.Healthcheck) float64 {
+	if hc.Error() != nil {
+		return 0
+	}
+	return 1
+}
+
+// familyBuilder creates the families of a metric
+type familyBuilder struct {
+	name     string
+	labels   map[string]string
+	group    string
+	md       driver.Metadata
+	created  time.Time
+	families []*family
+}
+
+// newFamily creates an empty family. The help and the unit of the metric are only
+// given to the main family, named as the metric.
+func (f *familyBuilder) newFamily(name, familyType string) *family {
+	fam := &family{Name: name, Type: familyType}
+	if name == f.name {
+		fam.Help = f.md.Help
+		fam.Unit = f.md.Unit
+	}
+	f.families = append(f.families, fam)
+	return fam
+}
+
+// add creates a family with a single sample
+func (f *familyBuilder) add(name, familyType string, value float64) {
+	f.addSample(f.newFamily(name, familyType), name, "", "", value)
+}
+
+// addSeconds creates a gauge family in seconds holding the duration given in nanoseconds
+func (f *familyBuilder) addSeconds(name string, nanoseconds float64) {
+	fam := f.newFamily(name, typeGauge)
+	fam.Unit = "seconds"
+	f.addSample(fam, name, "", "", nanoseconds/float64(time.Second))
+}
+
+// addSample adds a sample to the family, with an additional label if labelName is not empty
+func (f *familyBuilder) addSample(fam *family, name, labelName, labelValue string, value float64) {
+	labels := f.labels
+	if labelName != "" {
+		labels = driver.WithTag(f.labels, labelName, labelValue)
+	}
+	fam.Samples = append(fam.Samples, &sample{Name: name, Labels: labels, Value: value, group: f.group})
+}
+
+// addSummary creates the summary family of a histogram or a timer
+func (f *familyBuilder) addSummary(ps []float64, sum float64, count int64) {
+	summary := f.newFamily(f.name, typeSummary)
+	for idx, q := range quantiles {
+		f.addSample(summary, f.name, "quantile", formatFloat(q), ps[idx])
+	}
+	f.addSample(summary, f.name+"_sum", "", "", sum)
+	f.addSample(summary, f.name+"_count", "", "", float64(count))
+	f.addCreated(summary)
+}
+
+// addCounter creates the counter family of the metric, its sample having the _total suffix
+func (f *familyBuilder) addCounter(count int64) {
+	counter := f.newFamily(f.name, typeCounter)
+	f.addSample(counter, f.name+"_total", "", "", float64(count))
+	f.addCreated(counter)
+}
+
+// addCreated adds the _created sample to the family, as a unix timestamp in seconds
+func (f *familyBuilder) addCreated(fam *family) {
+	if f.created.IsZero() {
+		return
+	}
+	created := float64(f.created.UnixNano()) / float64(time.Second)
+	f.addSample(fam, fam.Name+"_created", "", "", created)
+}
+
+// mergeFamilies groups the families with the same name, sorts them by name and
+// their samples by metric, to encode them in a stable order
+func mergeFamilies(families []*family) []*family {
+	byName := map[string]*family{}
+	var ret []*family
+	for _, fam := range families {
+		existing, exists := byName[fam.Name]
+		if !exists {
+			byName[fam.Name] = fam
+			ret = append(ret, fam)
+			continue
+		}
+		existing.Samples = append(existing.Samples, fam.Samples...)
+		if existing.Help == "" {
+			existing.Help = fam.Help
+		}
+		if existing.Unit == "" {
+			existing.Unit = fam.Unit
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	for _, fam := range ret {
+		samples := fam.Samples
+		sort.SliceStable(samples, func(i, j int) bool {
+			return samples[i].group < samples[j].group
+		})
+	}
+
+	return ret
+}
+
+// sortedLabelNames returns the names of the labels in alphabetical order
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func labelsSignature(labels map[string]string) string {
+	var b strings.Builder
+	for _, name := range sortedLabelNames(labels) {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// sanitizeName returns a valid metric name : lower case, the invalid characters being replaced by _
+func sanitizeName(name string) string {
+	return sanitize(strings.ToLower(name), true)
+}
+
+// sanitizeLabels returns the labels with valid names, the invalid characters being replaced by _
+func sanitizeLabels(labels map[string]string) map[string]string {
+	ret := make(map[string]string, len(labels))
+	for k, v := range labels {
+		ret[sanitize(k, false)] = v
+	}
+	return ret
+}
+
+func sanitize(name string, allowColon bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') || (allowColon && c == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,108 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// filter selects the sections and the metrics to expose, from the query parameters :
+//   - section : the names of the sections, either the name of the registry or the section id
+//   - name : a regular expression the name of the metrics must match
+//   - name[] : the names of the metrics
+//   - tag.<key> : the value of the tag <key> of the metrics
+//
+// The names of the metrics are either the ones of the registry or the ones exposed in
+// the Prometheus formats, such as app_registry_requests. A nil filter matches everything.
+type filter struct {
+	sections map[string]struct{}
+	name     *regexp.Regexp
+	names    map[string]struct{}
+	tags     map[string]string
+}
+
+// parseFilter returns the filter of the query, nil if it has no filtering parameter
+func parseFilter(query url.Values) (*filter, error) {
+	f := &filter{}
+	empty := true
+
+	for key, values := range query {
+		switch {
+		case key == "section":
+			f.sections = toSet(values)
+
+		case key == "name[]":
+			f.names = toSet(values)
+
+		case key == "name":
+			re, err := regexp.Compile(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid name filter : %s", err)
+			}
+			f.name = re
+
+		case strings.HasPrefix(key, "tag."):
+			if f.tags == nil {
+				f.tags = map[string]string{}
+			}
+			f.tags[strings.TrimPrefix(key, "tag.")] = values[0]
+
+		default:
+			continue
+		}
+		empty = false
+	}
+
+	if empty {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// matchSection tells whether the metrics of the section can be exposed
+func (f *filter) matchSection(id string, s *section) bool {
+	if f == nil || f.sections == nil {
+		return true
+	}
+
+	_, byID := f.sections[id]
+	_, byName := f.sections[s.registryName]
+	return byID || byName
+}
+
+// matchMetric tells whether the metric, with its name in the registry, its exposed name and
+// its tags, can be exposed
+func (f *filter) matchMetric(name, exposedName string, tags map[string]string) bool {
+	if f == nil {
+		return true
+	}
+
+	if f.name != nil && !f.name.MatchString(name) && !f.name.MatchString(exposedName) {
+		return false
+	}
+
+	if f.names != nil {
+		_, byName := f.names[name]
+		_, byExposedName := f.names[exposedName]
+		if !byName && !byExposedName {
+			return false
+		}
+	}
+
+	for k, v := range f.tags {
+		if tags[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		set[v] = struct{}{}
+	}
+	return set
+}
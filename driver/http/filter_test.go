@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+func TestFilter(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("queries", r)
+	metrics.NewRegisteredCounter("errors", r)
+	metrics.NewRegisteredGauge("connections", r)
+	s := &section{name: "app_db", registryName: "db", registry: r, tags: map[string]string{"env": "prod"}}
+
+	for i, test := range []struct {
+		query    string
+		section  bool
+		expected []string
+	}{
+		//0 no filter
+		{"", true, []string{"connections", "errors", "queries"}},
+		//1 section by name
+		{"section=db&section=http", true, []string{"connections", "errors", "queries"}},
+		//2 section by id
+		{"section=db(env:prod)", true, []string{"connections", "errors", "queries"}},
+		//3 other section
+		{"section=http", false, nil},
+		//4 regex on the name
+		{"name=^(queries|errors)$", true, []string{"errors", "queries"}},
+		//5 regex on the exposed name
+		{"name=app_db_conn", true, []string{"connections"}},
+		//6 names
+		{"name[]=queries&name[]=app_db_connections", true, []string{"connections", "queries"}},
+		//7 matching tag
+		{"tag.env=prod", true, []string{"connections", "errors", "queries"}},
+		//8 other tag value
+		{"tag.env=dev", true, []string{}},
+		//9 unknown parameters are ignored
+		{"foo=bar", true, []string{"connections", "errors", "queries"}},
+	} {
+		query, _ := url.ParseQuery(test.query)
+		f, err := parseFilter(query)
+		if err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+
+		if match := f.matchSection("db(env:prod)", s); match != test.section {
+			t.Fatalf("test #%d failed : expected section match %t", i, test.section)
+		}
+		if !test.section {
+			continue
+		}
+
+		m, err := s.getMetrics(f)
+		if err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+		all := m.(map[string]map[string]interface{})
+		if len(all) != len(test.expected) {
+			t.Fatalf("test #%d failed : expected %v and got %v", i, test.expected, all)
+		}
+		for _, name := range test.expected {
+			if _, exists := all[name]; !exists {
+				t.Fatalf("test #%d failed : expected %v and got %v", i, test.expected, all)
+			}
+		}
+
+		families, err := s.getFamilies(f)
+		if err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+		if len(families) != len(test.expected) {
+			t.Fatalf("test #%d failed : expected %d families and got %d", i, len(test.expected), len(families))
+		}
+	}
+
+	if _, err := parseFilter(url.Values{"name": []string{"("}}); err == nil {
+		t.Fatal("expected an error on an invalid regex")
+	}
+}
@@ -0,0 +1,179 @@
+package http
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+// defaultHealthTTL is the time the result of a healthcheck is served before it is run again
+const defaultHealthTTL = time.Second
+
+// Statuses of the health responses
+const (
+	statusOK        = "ok"
+	statusUnhealthy = "unhealthy"
+	statusNotReady  = "not ready"
+)
+
+// healthStatus is the aggregated status of the healthchecks of all the sections
+type healthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkStatus `json:"checks"`
+}
+
+// checkStatus is the status of a healthcheck, keyed by the section id and the name of the metric
+type checkStatus struct {
+	Healthy bool      `json:"healthy"`
+	Error   string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+// healthz runs the healthchecks of all the sections, and responds with a 503 if one of them is
+// unhealthy. The result of a healthcheck run less than a second ago is reused.
+func (hd *Driver) healthz(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	hd.writeHealth(w, hd.checkHealth())
+}
+
+// readyz works as healthz, but also responds with a 503 until the driver has received
+// the registries for the first time
+func (hd *Driver) readyz(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	status := hd.checkHealth()
+	if atomic.LoadInt32(&hd.ready) == 0 {
+		status.Status = statusNotReady
+	}
+	hd.writeHealth(w, status)
+}
+
+func (hd *Driver) checkHealth() *healthStatus {
+	status := &healthStatus{Status: statusOK, Checks: map[string]checkStatus{}}
+
+	hd.rangeSections(nil, func(id string, s *section) bool {
+		s.m.RLock()
+		registry := s.registry
+		s.m.RUnlock()
+
+		if registry == nil {
+			return true
+		}
+
+		driver.Each(registry, s.tags, func(name string, i interface{}, _ driver.Metadata) {
+			hc, ok := i.(metrics.Healthcheck)
+			if !ok {
+				return
+			}
+
+			result := s.health.check(name, hc)
+			check := checkStatus{Healthy: true, Checked: result.checked}
+			if err := result.Error(); err != nil {
+				check = checkStatus{Error: err.Error(), Checked: result.checked}
+				status.Status = statusUnhealthy
+			}
+			status.Checks[id+"/"+name] = check
+		})
+		return true
+	})
+
+	return status
+}
+
+func (hd *Driver) writeHealth(w http.ResponseWriter, status *healthStatus) {
+	body, err := encodeJSON(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if status.Status != statusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(body)
+}
+
+// healthChecks runs the healthchecks of a section when their status is read. A result is reused
+// during the ttl, and the concurrent readers wait for the same run, so the probes do not pile up
+// and a healthcheck is never run concurrently by the driver. The healthchecks are keyed by name.
+type healthChecks struct {
+	m       sync.Mutex
+	ttl     time.Duration
+	entries map[string]*healthEntry
+}
+
+// healthEntry holds the last result of a healthcheck, its lock being held during a run
+type healthEntry struct {
+	m    sync.Mutex
+	last *checkedHealthcheck
+}
+
+func newHealthChecks(ttl time.Duration) *healthChecks {
+	return &healthChecks{ttl: ttl, entries: map[string]*healthEntry{}}
+}
+
+// check returns the result of the healthcheck, running it unless its last result is recent enough.
+// The results of the frozen registries are returned as is.
+func (c *healthChecks) check(name string, hc metrics.Healthcheck) *checkedHealthcheck {
+	if checked, ok := hc.(*checkedHealthcheck); ok {
+		return checked
+	}
+	if c == nil {
+		return runHealthcheck(hc)
+	}
+
+	c.m.Lock()
+	e, exists := c.entries[name]
+	if !exists {
+		e = &healthEntry{}
+		c.entries[name] = e
+	}
+	c.m.Unlock()
+
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	if e.last == nil || time.Since(e.last.checked) >= c.ttl {
+		e.last = runHealthcheck(hc)
+	}
+	return e.last
+}
+
+// reset drops the results, the healthchecks of a new registry being possibly different
+func (c *healthChecks) reset() {
+	if c == nil {
+		return
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.entries = map[string]*healthEntry{}
+}
+
+// checkedHealthcheck is the result of a healthcheck. Checking it again does nothing, so it can
+// be read concurrently as any other metrics.Healthcheck.
+type checkedHealthcheck struct {
+	err     error
+	checked time.Time
+}
+
+func runHealthcheck(hc metrics.Healthcheck) *checkedHealthcheck {
+	hc.Check()
+	return &checkedHealthcheck{err: hc.Error(), checked: time.Now()}
+}
+
+// Check does nothing, the healthcheck has already been run.
+func (h *checkedHealthcheck) Check() {}
+
+// Error returns the error of the healthcheck, nil if it was healthy.
+func (h *checkedHealthcheck) Error() error { return h.err }
+
+// Healthy does nothing, the result of the healthcheck cannot be changed.
+func (h *checkedHealthcheck) Healthy() {}
+
+// Unhealthy does nothing, the result of the healthcheck cannot be changed.
+func (h *checkedHealthcheck) Unhealthy(error) {}
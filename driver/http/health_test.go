@@ -0,0 +1,116 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestHealth(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every request runs the healthchecks
+	hd.healthTTL = 0
+
+	get := func(path string) (int, *healthStatus) {
+		w := httptest.NewRecorder()
+		hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var status healthStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatalf("invalid response %s : %s", w.Body.String(), err)
+		}
+		return w.Code, &status
+	}
+
+	// Not ready until the first send
+	if code, status := get("/readyz"); code != http.StatusServiceUnavailable || status.Status != statusNotReady {
+		t.Fatalf("unexpected readiness %d %v", code, status)
+	}
+	if code, _ := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("unexpected health %d", code)
+	}
+
+	var dbErr error
+	r := metrics.NewRegistry()
+	r.Register("db", metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		if dbErr != nil {
+			h.Unhealthy(dbErr)
+			return
+		}
+		h.Healthy()
+	}))
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+
+	if code, status := get("/readyz"); code != http.StatusOK || !status.Checks["reg()/db"].Healthy {
+		t.Fatalf("unexpected readiness %d %v", code, status)
+	}
+
+	// The healthchecks are run by the requests, without waiting for the next flush
+	dbErr = errors.New("connection refused")
+	code, status := get("/healthz")
+	if code != http.StatusServiceUnavailable || status.Status != statusUnhealthy || status.Checks["reg()/db"].Error != "connection refused" {
+		t.Fatalf("unexpected health %d %v", code, status)
+	}
+
+	// The health is exported as a gauge
+	req := httptest.NewRequest("GET", "/sections/metrics", nil)
+	req.Header.Set("Accept", "text/plain; version=0.0.4")
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "# TYPE app_reg_db_healthy gauge\napp_reg_db_healthy 0\n") {
+		t.Fatalf("unexpected metrics %s", w.Body.String())
+	}
+}
+
+func TestHealthTTL(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hd.healthTTL = time.Hour
+
+	var checks int32
+	r := metrics.NewRegistry()
+	r.Register("db", metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		atomic.AddInt32(&checks, 1)
+		time.Sleep(10 * time.Millisecond)
+		h.Healthy()
+	}))
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+
+	// The concurrent probes wait for the same run, the next ones reuse its result
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hd.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+		}()
+	}
+	wg.Wait()
+
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	var status healthStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Checks["reg()/db"].Checked.IsZero() {
+		t.Fatalf("expected the time of the check and got %s", w.Body.String())
+	}
+	// The flush, recording the history, and the probes share a single run
+	if checks := atomic.LoadInt32(&checks); checks != 1 {
+		t.Fatalf("expected the healthcheck to run once and it ran %d times", checks)
+	}
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultHistorySize is the number of snapshots kept for each section, unless configured otherwise
+const defaultHistorySize = 30
+
+// snapshot holds the values of the metrics of a section at a flush, as exposed in JSON
+type snapshot struct {
+	Time   time.Time                         `json:"time"`
+	Values map[string]map[string]interface{} `json:"values"`
+}
+
+// history is a ring buffer of the last snapshots of a section
+type history struct {
+	m         sync.RWMutex
+	snapshots []*snapshot
+	// next is the index of the oldest snapshot, overwritten by the next one once the buffer is full
+	next int
+}
+
+func newHistory(size int) *history {
+	return &history{snapshots: make([]*snapshot, 0, size)}
+}
+
+// add records a snapshot, replacing the oldest one if the buffer is full
+func (h *history) add(s *snapshot) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	if len(h.snapshots) < cap(h.snapshots) {
+		h.snapshots = append(h.snapshots, s)
+		return
+	}
+	if len(h.snapshots) == 0 {
+		return
+	}
+	h.snapshots[h.next] = s
+	h.next = (h.next + 1) % len(h.snapshots)
+}
+
+// since returns the snapshots taken after the time given, from the oldest to the newest
+func (h *history) since(t time.Time) []*snapshot {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	ret := []*snapshot{}
+	for i := range h.snapshots {
+		s := h.snapshots[(h.next+i)%len(h.snapshots)]
+		if s.Time.After(t) {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// parseSince returns the time of the since parameter of the history, either a duration before
+// now such as 5m, a RFC 3339 time or a unix timestamp in seconds. It is the zero time if empty.
+func parseSince(raw string, now time.Time) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if ts, err := strconv.ParseFloat(raw, 64); err == nil {
+		return time.Unix(0, int64(ts*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid since %s : expected a duration, a RFC 3339 time or a unix timestamp", raw)
+}
+
+// showHistory returns the values of the metrics of a section at the last flushes
+func (hd *Driver) showHistory(w http.ResponseWriter, r *http.Request, args map[string]string) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	since, err := parseSince(r.URL.Query().Get("since"), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sectionRaw, exists := hd.sections.Load(args["name"])
+	if !exists {
+		http.Error(w, "section not found", http.StatusNotFound)
+		return
+	}
+
+	snapshots, err := sectionRaw.(*section).getHistory(since, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := encodeJSON(snapshots)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(body)
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestHistory(t *testing.T) {
+	h := newHistory(3)
+	start := time.Unix(1000, 0)
+	for i := 0; i < 5; i++ {
+		h.add(&snapshot{Time: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	for i, test := range []struct {
+		since    time.Time
+		expected []int64
+	}{
+		//0 all the snapshots kept, the oldest ones being dropped
+		{time.Time{}, []int64{1002, 1003, 1004}},
+		//1 the snapshots after a time
+		{start.Add(2 * time.Second), []int64{1003, 1004}},
+		//2 no snapshot
+		{start.Add(time.Minute), []int64{}},
+	} {
+		snapshots := h.since(test.since)
+		if len(snapshots) != len(test.expected) {
+			t.Fatalf("test #%d failed : expected %d snapshots and got %d", i, len(test.expected), len(snapshots))
+		}
+		for j, s := range snapshots {
+			if s.Time.Unix() != test.expected[j] {
+				t.Fatalf("test #%d failed : unexpected snapshot at %s", i, s.Time)
+			}
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for i, test := range []struct {
+		raw      string
+		expected time.Time
+		err      bool
+	}{
+		//0 empty
+		{"", time.Time{}, false},
+		//1 duration
+		{"5m", now.Add(-5 * time.Minute), false},
+		//2 RFC 3339
+		{"1970-01-01T00:10:00Z", time.Unix(600, 0), false},
+		//3 unix timestamp
+		{"900.5", time.Unix(900, 500000000), false},
+		//4 invalid
+		{"yesterday", time.Time{}, true},
+	} {
+		since, err := parseSince(test.raw, now)
+		if (err != nil) != test.err {
+			t.Fatalf("test #%d failed : unexpected error %v", i, err)
+		}
+		if !since.Equal(test.expected) {
+			t.Fatalf("test #%d failed : expected %s and got %s", i, test.expected, since)
+		}
+	}
+}
+
+func TestShowHistory(t *testing.T) {
+	hd, err := New(Options{Name: "app", HistorySize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	metrics.NewRegisteredGauge("connections", r)
+	for i := 0; i < 3; i++ {
+		c.Inc(1)
+		hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+	}
+
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/section/reg()/history?since=1h&name=requests", nil))
+	var snapshots []*snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshots); err != nil {
+		t.Fatalf("invalid response %s : %s", w.Body.String(), err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots and got %d", len(snapshots))
+	}
+	for i, s := range snapshots {
+		if len(s.Values) != 1 || s.Values["requests"]["count"] != float64(i+2) {
+			t.Fatalf("unexpected snapshot %v", s.Values)
+		}
+	}
+
+	for path, expected := range map[string]int{
+		"/section/other/history":         http.StatusNotFound,
+		"/section/reg()/history?since=x": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != expected {
+			t.Fatalf("expected status %d for %s and got %d", expected, path, w.Code)
+		}
+	}
+}
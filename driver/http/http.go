@@ -4,45 +4,185 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	treemux "github.com/dimfeld/httptreemux/v5"
 	"github.com/ybriffa/metrics/driver"
 )
 
-type httpDriver struct {
+const jsonContentType = "application/json"
+
+// Driver exposes the registries sent to it through its HTTP handler. It implements driver.Driver.
+type Driver struct {
 	treemux  *treemux.TreeMux
 	sections sync.Map
-	m        sync.RWMutex
-	name     string
+	cache    *responseCache
+	streams  *streams
+	// ready is set once the registries have been sent for the first time
+	ready int32
+
+	// m protects the configuration and the server
+	m           sync.RWMutex
+	name        string
+	auth        *authConfig
+	historySize int
+	mode        string
+	cacheTTL    time.Duration
+	healthTTL   time.Duration
+	server      *http.Server
+	listener    net.Listener
+
+	// flushed is the time of the last Send, as UnixNano
+	flushed int64
+}
+
+// Options are the options of a Driver created with New
+type Options struct {
+	// Name is the name of the application, prefixing the names of the sections
+	Name string
+	// ConfigStoreAlias is the configstore item configuring the driver, in the same format as
+	// http-metrics. The driver is neither protected nor listening on its own if it is empty.
+	ConfigStoreAlias string
+	// HistorySize is the number of flushes kept in the history of each section, 30 by default.
+	// It is overridden by the history_size of the configstore item.
+	HistorySize int
+	// Mode is either ModeLive, serving the values of the metrics at the time of the requests,
+	// or ModeSnapshot, serving the values of the last flush. ModeLive by default.
+	// It is overridden by the mode of the configstore item.
+	Mode string
+	// CacheTTL is the duration the responses are cached in live mode, so the frequent scrapers
+	// do not encode the same metrics again and again, at the cost of values up to CacheTTL old.
+	// The responses are built at each request by default, the snapshot mode caching them until
+	// the next flush. It is overridden by the cache_ttl of the configstore item.
+	CacheTTL time.Duration
 }
 
 var (
-	handler = &httpDriver{
-		treemux: treemux.New(),
-	}
+	// handler is the default driver, instantiated by the driver factory and exposed by GetHandler
+	handler = newDriver()
 )
 
 func init() {
 	// registers the metric
 	driver.Register("http", driver.FactoryFunc(factory))
-
-	handler.treemux.RedirectBehavior = treemux.UseHandler
-	handler.treemux.Handle("GET", "/sections", handler.listSections)
-	handler.treemux.Handle("GET", "/sections/metrics", handler.expandSections)
-	handler.treemux.Handle("GET", "/section/:name", handler.showSection)
 }
 
 // factory is the function creating a new OpenTSDB Sender through the driver.Factory
 func factory(name string) (driver.Driver, error) {
-	handler.name = name
+	c, err := loadConfig(configStoreAlias)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handler.configure(name, c); err != nil {
+		return nil, err
+	}
 	return handler, nil
 }
 
-func (hd *httpDriver) listSections(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+// New creates a driver independent from the default one, with its own handler and sections.
+// It can be registered to the metrics with driver.Register.
+func New(opts Options) (*Driver, error) {
+	c := &config{}
+	if opts.ConfigStoreAlias != "" {
+		var err error
+		if c, err = loadConfig(opts.ConfigStoreAlias); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.HistorySize == 0 {
+		c.HistorySize = opts.HistorySize
+	}
+	if c.Mode == "" {
+		c.Mode = opts.Mode
+	}
+	if c.CacheTTL == "" && opts.CacheTTL != 0 {
+		c.CacheTTL = opts.CacheTTL.String()
+	}
+
+	hd := newDriver()
+	if err := hd.configure(opts.Name, c); err != nil {
+		return nil, err
+	}
+	return hd, nil
+}
+
+func newDriver() *Driver {
+	hd := &Driver{
+		treemux: treemux.New(),
+		cache:   newResponseCache(),
+		streams: newStreams(),
+		// The result of a healthcheck is reused during a second, so the probes do not pile up
+		healthTTL: defaultHealthTTL,
+	}
+
+	hd.treemux.RedirectBehavior = treemux.UseHandler
+	hd.handle("/", hd.showDashboard)
+	hd.handle("/sections", hd.listSections)
+	hd.handle("/sections/metrics", hd.expandSections)
+	hd.handle("/sections/stream", hd.streamSections)
+	hd.handle("/section/:name", hd.showSection)
+	hd.handle("/section/:name/history", hd.showHistory)
+	hd.handle("/healthz", hd.healthz)
+	hd.handle("/readyz", hd.readyz)
+	return hd
+}
+
+// configure sets the name and the configuration of the driver, starting its server if needed.
+// The server started by a previous configuration is shut down.
+func (hd *Driver) configure(name string, c *config) error {
+	mode := c.Mode
+	switch mode {
+	case "":
+		mode = ModeLive
+	case ModeLive, ModeSnapshot:
+	default:
+		return ErrUnknownMode
+	}
+
+	var cacheTTL time.Duration
+	if c.CacheTTL != "" {
+		var err error
+		if cacheTTL, err = time.ParseDuration(c.CacheTTL); err != nil || cacheTTL < 0 {
+			return ErrInvalidCacheTTL
+		}
+	}
+
+	hd.m.Lock()
+	hd.name = name
+	hd.auth = c.Auth
+	hd.historySize = c.HistorySize
+	if hd.historySize <= 0 {
+		hd.historySize = defaultHistorySize
+	}
+	hd.mode = mode
+	hd.cacheTTL = cacheTTL
+	hd.m.Unlock()
+
+	if c.Listen != "" {
+		return hd.listen(c)
+	}
+	return hd.shutdown()
+}
+
+// Handler returns the HTTP handler to register to expose the metrics of the driver
+func (hd *Driver) Handler() http.Handler {
+	return hd.treemux
+}
+
+// handle registers the handler of the GET requests on the route, protected by its access rule
+func (hd *Driver) handle(pattern string, h treemux.HandlerFunc) {
+	hd.treemux.Handle("GET", pattern, hd.authorize(pattern, h))
+}
+
+func (hd *Driver) listSections(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	names := []string{}
 	hd.sections.Range(func(k, _ interface{}) bool {
 		names = append(names, k.(string))
@@ -53,63 +193,98 @@ func (hd *httpDriver) listSections(w http.ResponseWriter, r *http.Request, _ map
 	e.Encode(names)
 }
 
-func (hd *httpDriver) expandSections(w http.ResponseWriter, r *http.Request, m map[string]string) {
-	accept := r.Header.Get("Accept")
-	if strings.ToLower(accept) == "application/prometheus" {
-		hd.expandSectionsPrometheus(w, r, m)
+func (hd *Driver) expandSections(w http.ResponseWriter, r *http.Request, m map[string]string) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if f := negotiate(r.Header.Get("Accept")); f != nil {
+		hd.writeCached(w, r, f.contentType, func() ([]byte, error) {
+			return hd.encodeSections(f, filter)
+		})
 		return
 	}
 
-	result := map[string]interface{}{}
+	if r.URL.Query().Get("schema") != legacySchema {
+		hd.writeCached(w, r, jsonContentType, func() ([]byte, error) {
+			now := time.Now()
+			doc := &document{Version: schemaVersion, Timestamp: now, Sections: []*jsonSection{}}
+			at := hd.valuesTime(now)
+			hd.rangeSections(filter, func(id string, section *section) bool {
+				doc.Sections = append(doc.Sections, section.getSection(id, filter, at))
+				return true
+			})
+			sort.Slice(doc.Sections, func(i, j int) bool {
+				return doc.Sections[i].ID < doc.Sections[j].ID
+			})
 
-	hd.sections.Range(func(k, v interface{}) bool {
-		section := v.(*section)
+			return encodeJSON(doc)
+		})
+		return
+	}
 
-		metrics, err := section.getMetrics()
-		if err == nil {
-			result[k.(string)] = metrics
-		}
-		return true
-	})
+	hd.writeCached(w, r, jsonContentType, func() ([]byte, error) {
+		result := map[string]interface{}{}
 
-	e := json.NewEncoder(w)
-	e.Encode(result)
+		hd.rangeSections(filter, func(id string, section *section) bool {
+			metrics, err := section.getMetrics(filter)
+			if err == nil {
+				result[id] = metrics
+			}
+			return true
+		})
+
+		return encodeJSON(result)
+	})
 }
 
-func (hd *httpDriver) expandSectionsPrometheus(w http.ResponseWriter, r *http.Request, m map[string]string) {
+// encodeSections encodes the metrics of all the sections matching the filter in the exposition format given
+func (hd *Driver) encodeSections(f *format, filter *filter) ([]byte, error) {
 	var itError error
 
-	metrics := []*GTS{}
-	hd.sections.Range(func(k, v interface{}) bool {
-		section := v.(*section)
-
-		gts, err := section.getGTS()
+	families := []*family{}
+	hd.rangeSections(filter, func(_ string, section *section) bool {
+		sectionFamilies, err := section.getFamilies(filter)
 		if err != nil {
 			itError = err
 			return false
 		}
 
-		metrics = append(metrics, gts...)
+		families = append(families, sectionFamilies...)
 		return true
 	})
 
 	if itError != nil {
-		http.Error(w, itError.Error(), http.StatusInternalServerError)
-		return
+		return nil, itError
 	}
 
 	var b bytes.Buffer
-	sort.SliceStable(metrics, func(i, j int) bool {
-		return metrics[i].Name < metrics[j].Name
-	})
-	for _, metric := range metrics {
-		b.Write(metric.Encode())
+	if err := f.encode(&b, mergeFamilies(families)); err != nil {
+		return nil, err
 	}
+	return b.Bytes(), nil
+}
 
-	w.Write(b.Bytes())
+// rangeSections calls fn for each section matching the filter, until it returns false
+func (hd *Driver) rangeSections(filter *filter, fn func(string, *section) bool) {
+	hd.sections.Range(func(k, v interface{}) bool {
+		id, section := k.(string), v.(*section)
+		if !filter.matchSection(id, section) {
+			return true
+		}
+		return fn(id, section)
+	})
 }
 
-func (hd *httpDriver) showSection(w http.ResponseWriter, r *http.Request, args map[string]string) {
+func (hd *Driver) showSection(w http.ResponseWriter, r *http.Request, args map[string]string) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Load the section from the map
 	sectionRaw, exists := hd.sections.Load(args["name"])
 	if !exists {
@@ -124,35 +299,153 @@ func (hd *httpDriver) showSection(w http.ResponseWriter, r *http.Request, args m
 		return
 	}
 
-	// Get the metrics
-	m, err := section.getMetrics()
+	if r.URL.Query().Get("schema") != legacySchema {
+		hd.writeCached(w, r, jsonContentType, func() ([]byte, error) {
+			now := time.Now()
+			return encodeJSON(&document{
+				Version:   schemaVersion,
+				Timestamp: now,
+				Sections:  []*jsonSection{section.getSection(args["name"], filter, hd.valuesTime(now))},
+			})
+		})
+		return
+	}
+
+	hd.writeCached(w, r, jsonContentType, func() ([]byte, error) {
+		// Get the metrics
+		m, err := section.getMetrics(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		// Encode them as json
+		return encodeJSON(m)
+	})
+}
+
+// writeCached writes the response built by render, compressed as negotiated with the Accept-Encoding
+// header, and not written again to the clients already having it according to their If-None-Match
+// header. In snapshot mode, the response is cached until the next Send, and its Last-Modified
+// header is the time of the last flush. In live mode, it is built from the current values of
+// the metrics at each request, or cached during the cache TTL if one is configured.
+func (hd *Driver) writeCached(w http.ResponseWriter, r *http.Request, contentType string, render func() ([]byte, error)) {
+	hd.m.RLock()
+	live, ttl := hd.mode != ModeSnapshot, hd.cacheTTL
+	hd.m.RUnlock()
+	cached := !live || ttl > 0
+
+	var resp *cachedResponse
+	var generation uint64
+	key := r.URL.Path + "?" + r.URL.RawQuery + "\n" + contentType
+	if cached {
+		resp, generation = hd.cache.get(key)
+	}
+	if resp != nil && live && time.Since(resp.built) >= ttl {
+		resp = nil
+	}
+	if resp == nil {
+		body, err := render()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp = newCachedResponse(body, contentType)
+		if cached {
+			hd.cache.set(key, resp, generation)
+		}
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	etag := resp.etag(encoding)
+
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+	w.Header().Set("ETag", etag)
+	if t := hd.snapshotTime(); !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	body, err := resp.encode(encoding)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Encode them as json
-	e := json.NewEncoder(w)
-	e.Encode(m)
+	w.Header().Set("Content-Type", resp.contentType)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Write(body)
+}
+
+// snapshotTime returns the time of the last flush when the driver serves its values, zero otherwise
+func (hd *Driver) snapshotTime() time.Time {
+	hd.m.RLock()
+	mode := hd.mode
+	hd.m.RUnlock()
+
+	flushed := atomic.LoadInt64(&hd.flushed)
+	if mode != ModeSnapshot || flushed == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, flushed)
+}
+
+// valuesTime returns the time of the values served : the last flush in snapshot mode, and the
+// time of the request given in live mode, the values being read while rendering it
+func (hd *Driver) valuesTime(now time.Time) time.Time {
+	if t := hd.snapshotTime(); !t.IsZero() {
+		return t
+	}
+	return now
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
 
 // Send is the implementation of the driver.Registry.Sent. It exposes
 // the registry given and deletes the old registries not declared in this array
-func (hd *httpDriver) Send(registries []*driver.Registry) error {
+func (hd *Driver) Send(registries []*driver.Registry) error {
+	hd.m.RLock()
+	name, historySize, mode, healthTTL := hd.name, hd.historySize, hd.mode, hd.healthTTL
+	hd.m.RUnlock()
+
 	// First, range over all the registries to either create the entry or
 	// update the metrics.Registry of the section.
 	var registriesSent []string
+	now := time.Now()
 	for _, registry := range registries {
 		id := hd.computeSectionID(registry.Name, registry.Tags)
-		sectionRaw, loaded := hd.sections.LoadOrStore(id, &section{
-			name:     fmt.Sprintf("%s_%s", hd.name, registry.Name),
-			registry: registry.Registry,
-			tags:     registry.Tags,
-		})
+		r := registry.Registry
+		if mode == ModeSnapshot {
+			r = freeze(r)
+		}
+
+		sectionRaw, loaded := hd.sections.Load(id)
+		if !loaded {
+			sectionRaw, loaded = hd.sections.LoadOrStore(id, &section{
+				name:         fmt.Sprintf("%s_%s", name, registry.Name),
+				registryName: registry.Name,
+				registry:     r,
+				tags:         registry.Tags,
+				created:      now,
+				history:      newHistory(historySize),
+				health:       newHealthChecks(healthTTL),
+			})
+		}
 		// If the section already existed, update its metrics registry
 		if loaded {
-			sectionRaw.(*section).setRegistry(registry.Registry)
+			sectionRaw.(*section).setRegistry(r)
 		}
+		sectionRaw.(*section).record(now)
 		// Save the name of the section to know which one to delete after
 		registriesSent = append(registriesSent, id)
 	}
@@ -178,10 +471,16 @@ func (hd *httpDriver) Send(registries []*driver.Registry) error {
 		hd.sections.Delete(name)
 	}
 
+	// The responses cached describe the previous registries
+	hd.cache.reset()
+	atomic.StoreInt64(&hd.flushed, now.UnixNano())
+	atomic.StoreInt32(&hd.ready, 1)
+	hd.streams.publish(now)
+
 	return nil
 }
 
-func (hd *httpDriver) computeSectionID(name string, rawTags map[string]string) string {
+func (hd *Driver) computeSectionID(name string, rawTags map[string]string) string {
 	tags := []string{}
 	for k, v := range rawTags {
 		tags = append(tags, fmt.Sprintf("%s:%s", k, v))
@@ -190,7 +489,7 @@ func (hd *httpDriver) computeSectionID(name string, rawTags map[string]string) s
 	return fmt.Sprintf("%s(%s)", name, strings.Join(tags, ","))
 }
 
-// GetHandler returns the HTTP handler to register to expose the metrics
+// GetHandler returns the HTTP handler to register to expose the metrics of the default driver
 func GetHandler() http.Handler {
-	return handler.treemux
+	return handler.Handler()
 }
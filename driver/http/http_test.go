@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestNew(t *testing.T) {
+	first, err := New(Options{Name: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(Options{Name: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("requests", r)
+	first.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+
+	for i, test := range []struct {
+		d        *Driver
+		expected string
+	}{
+		//0 driver the registry was sent to
+		{first, "first_reg_requests_total 0"},
+		//1 other driver
+		{second, ""},
+	} {
+		req := httptest.NewRequest("GET", "/sections/metrics", nil)
+		req.Header.Set("Accept", "text/plain; version=0.0.4")
+		w := httptest.NewRecorder()
+		test.d.Handler().ServeHTTP(w, req)
+
+		body := strings.TrimSpace(w.Body.String())
+		if test.expected == "" && body != "" || !strings.Contains(body, test.expected) {
+			t.Fatalf("test #%d failed : unexpected body %s", i, body)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// shutdownTimeout is the time given to the requests in progress to complete when the driver stops
+const shutdownTimeout = 5 * time.Second
+
+// listen starts a server exposing the routes of the driver under the prefix given, in TLS if
+// the certificate and the key are given. The certificate is loaded and the address is bound
+// before returning, so their errors are reported to the caller. The server previously started
+// by the driver, if any, is shut down first.
+func (hd *Driver) listen(c *config) error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("both tls_cert and tls_key must be set to listen in TLS")
+	}
+
+	var tlsConfig *tls.Config
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return err
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	var h http.Handler = hd.treemux
+	if prefix := strings.TrimSuffix(c.Prefix, "/"); prefix != "" {
+		// The treemux routes the requests on their RequestURI, not updated by StripPrefix
+		h = http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RequestURI = r.URL.RequestURI()
+			hd.treemux.ServeHTTP(w, r)
+		}))
+	}
+
+	// The previous server may be bound to the same address
+	if err := hd.shutdown(); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", c.Listen)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	srv := &http.Server{Handler: h}
+	hd.m.Lock()
+	hd.server = srv
+	hd.listener = l
+	hd.m.Unlock()
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.Errorf("[metrics] http driver stopped listening on %s : %s", c.Listen, err)
+		}
+	}()
+
+	log.Debugf("[metrics] http driver listening on %s", l.Addr())
+	return nil
+}
+
+// shutdown shuts down the server of the driver, if any, waiting for the requests in progress
+// to complete
+func (hd *Driver) shutdown() error {
+	hd.m.Lock()
+	srv, l := hd.server, hd.listener
+	hd.server, hd.listener = nil, nil
+	hd.m.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	// The server may not be serving yet, the address must be released anyway
+	l.Close()
+	return err
+}
+
+// Stop is the implementation of driver.Stopper. It ends the streams and shuts down the
+// server of the driver, if any, waiting for the requests in progress to complete.
+func (hd *Driver) Stop() error {
+	hd.streams.close()
+	return hd.shutdown()
+}
@@ -0,0 +1,65 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	// Find a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	hd := newDriver()
+
+	if err := hd.listen(&config{Listen: addr, TLSCert: "cert.pem"}); err == nil {
+		t.Fatal("expected an error without the tls key")
+	}
+	if err := hd.listen(&config{Listen: addr, TLSCert: "missing.pem", TLSKey: "missing.key"}); err == nil {
+		t.Fatal("expected an error loading the missing certificate")
+	}
+	if err := hd.listen(&config{Listen: addr}); err != nil {
+		t.Fatal(err)
+	}
+	// Listening again on the same address replaces the previous server
+	if err := hd.listen(&config{Listen: addr, Prefix: "/metrics/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics/sections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+
+	if err := hd.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get("http://" + addr + "/metrics/sections"); err == nil {
+		t.Fatal("expected the server to be stopped")
+	}
+	if err := hd.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestListenAddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	hd := newDriver()
+	if err := hd.configure("app", &config{Listen: l.Addr().String()}); err == nil {
+		t.Fatal("expected an error listening on an address in use")
+	}
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// format is an exposition format of the metrics, selected through the Accept header
+type format struct {
+	contentType string
+	// match tells whether the media range of the Accept header designates the format
+	match  func(mediaType string, params map[string]string) bool
+	encode func(w io.Writer, families []*family) error
+}
+
+var (
+	openMetricsFormat = &format{
+		contentType: "application/openmetrics-text; version=1.0.0; charset=utf-8",
+		match: func(mediaType string, params map[string]string) bool {
+			version, hasVersion := params["version"]
+			return mediaType == "application/openmetrics-text" && (!hasVersion || version == "1.0.0")
+		},
+		encode: writeOpenMetrics,
+	}
+
+	prometheusFormat = &format{
+		contentType: "text/plain; version=0.0.4; charset=utf-8",
+		match: func(mediaType string, params map[string]string) bool {
+			if mediaType == "application/prometheus" {
+				return true
+			}
+			version, hasVersion := params["version"]
+			return mediaType == "text/plain" && (!hasVersion || version == "0.0.4")
+		},
+		encode: writePrometheusText,
+	}
+
+	protobufFormat = &format{
+		contentType: "application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited",
+		match: func(mediaType string, params map[string]string) bool {
+			encoding, hasEncoding := params["encoding"]
+			return mediaType == "application/vnd.google.protobuf" &&
+				params["proto"] == "io.prometheus.client.MetricFamily" &&
+				(!hasEncoding || encoding == "delimited")
+		},
+		encode: writeProtobuf,
+	}
+
+	// formats are the exposition formats handled
+	formats = []*format{protobufFormat, openMetricsFormat, prometheusFormat}
+)
+
+type mediaRange struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// negotiate returns the format with the highest quality in the Accept header, or nil
+// if none matches, in which case the metrics are exposed as JSON
+func negotiate(accept string) *format {
+	var ranges []mediaRange
+	for _, raw := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(raw))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if rawQ, exists := params["q"]; exists {
+			if q, err = strconv.ParseFloat(rawQ, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ranges = append(ranges, mediaRange{mediaType: mediaType, params: params, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		if r.mediaType == "application/json" || r.mediaType == "*/*" {
+			return nil
+		}
+		for _, f := range formats {
+			if f.match(r.mediaType, r.params) {
+				return f
+			}
+		}
+	}
+
+	return nil
+}
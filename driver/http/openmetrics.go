@@ -0,0 +1,38 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// writeOpenMetrics encodes the families in the OpenMetrics text format 1.0.0. As required by
+// the format, the name of the families with a unit is suffixed with it.
+func writeOpenMetrics(w io.Writer, families []*family) error {
+	b := bufio.NewWriter(w)
+
+	for _, fam := range families {
+		name := fam.Name
+		unit := sanitize(fam.Unit, false)
+		if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+			name += "_" + unit
+		}
+
+		b.WriteString("# TYPE " + name + " " + fam.Type + "\n")
+		if unit != "" {
+			b.WriteString("# UNIT " + name + " " + unit + "\n")
+		}
+		if fam.Help != "" {
+			b.WriteString("# HELP " + name + " " + labelValueReplacer.Replace(fam.Help) + "\n")
+		}
+		for _, s := range fam.Samples {
+			renamed := *s
+			renamed.Name = name + strings.TrimPrefix(s.Name, fam.Name)
+			writeSample(b, &renamed)
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteString("# EOF\n")
+
+	return b.Flush()
+}
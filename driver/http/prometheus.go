@@ -0,0 +1,73 @@
+package http
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strings"
+)
+
+var (
+	helpReplacer       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// writePrometheusText encodes the families in the Prometheus text format 0.0.4, where the
+// counters are named with their _total suffix and the created timestamps are not written
+func writePrometheusText(w io.Writer, families []*family) error {
+	b := bufio.NewWriter(w)
+
+	for _, fam := range families {
+		name := fam.Name
+		if fam.Type == typeCounter {
+			name += "_total"
+		}
+		if fam.Help != "" {
+			b.WriteString("# HELP " + name + " " + helpReplacer.Replace(fam.Help) + "\n")
+		}
+		b.WriteString("# TYPE " + name + " " + fam.Type + "\n")
+		if fam.Unit != "" {
+			// Not part of the 0.0.4 format, but a valid comment for the parsers
+			b.WriteString("# UNIT " + name + " " + fam.Unit + "\n")
+		}
+		for _, s := range fam.Samples {
+			if s.Name == fam.Name+"_created" {
+				continue
+			}
+			writeSample(b, s)
+			b.WriteByte('\n')
+		}
+	}
+
+	return b.Flush()
+}
+
+// writeSample writes the name, the labels sorted by name and the value of the sample
+func writeSample(b *bufio.Writer, s *sample) {
+	b.WriteString(s.Name)
+	if len(s.Labels) > 0 {
+		b.WriteByte('{')
+		for i, name := range sortedLabelNames(s.Labels) {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(name + `="` + labelValueReplacer.Replace(s.Labels[name]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatValue(s.Value))
+}
+
+// formatValue formats a sample value, with the special values spelled as expected by Prometheus
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return formatFloat(v)
+}
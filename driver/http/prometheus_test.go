@@ -0,0 +1,230 @@
+package http
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+func TestNegotiate(t *testing.T) {
+	for i, test := range []struct {
+		accept   string
+		expected *format
+	}{
+		//0 no header
+		{"", nil},
+		//1 json
+		{"application/json", nil},
+		//2 prometheus text format
+		{"text/plain; version=0.0.4", prometheusFormat},
+		//3 legacy header
+		{"application/prometheus", prometheusFormat},
+		//4 prometheus scraper, with an unknown format preferred
+		{"application/unknown;q=0.9,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", prometheusFormat},
+		//5 openmetrics preferred
+		{"application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5", openMetricsFormat},
+		//6 openmetrics unhandled version
+		{"application/openmetrics-text;version=0.0.1,text/plain;version=0.0.4;q=0.5", prometheusFormat},
+		//7 wildcard preferred
+		{"*/*,text/plain;q=0.5", nil},
+		//8 unhandled version
+		{"text/plain; version=0.0.5", nil},
+		//9 protobuf, as sent by the prometheus scrapers
+		{"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3", protobufFormat},
+		//10 protobuf with another message
+		{"application/vnd.google.protobuf;proto=other", nil},
+	} {
+		if f := negotiate(test.accept); f != test.expected {
+			t.Fatalf("test #%d failed : unexpected format %v", i, f)
+		}
+	}
+}
+
+func TestWritePrometheusText(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("requests", r).Inc(3)
+	metrics.NewRegisteredGaugeFloat64("ratio", r).Update(0.5)
+	h := metrics.NewRegisteredHistogram("latency", r, metrics.NewUniformSample(10))
+	h.Update(1)
+	h.Update(3)
+
+	s := &section{name: "app_reg", registry: r, tags: map[string]string{"z": "1", "a": "quote\"d\n"}}
+	families, err := s.getFamilies(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := writePrometheusText(&b, mergeFamilies(families)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# TYPE app_reg_latency summary
+app_reg_latency{a="quote\"d\n",quantile="0.5",z="1"} 2
+app_reg_latency{a="quote\"d\n",quantile="0.75",z="1"} 3
+app_reg_latency{a="quote\"d\n",quantile="0.95",z="1"} 3
+app_reg_latency{a="quote\"d\n",quantile="0.99",z="1"} 3
+app_reg_latency{a="quote\"d\n",quantile="0.999",z="1"} 3
+app_reg_latency_sum{a="quote\"d\n",z="1"} 4
+app_reg_latency_count{a="quote\"d\n",z="1"} 2
+# TYPE app_reg_latency_max gauge
+app_reg_latency_max{a="quote\"d\n",z="1"} 3
+# TYPE app_reg_latency_mean gauge
+app_reg_latency_mean{a="quote\"d\n",z="1"} 2
+# TYPE app_reg_latency_min gauge
+app_reg_latency_min{a="quote\"d\n",z="1"} 1
+# TYPE app_reg_latency_std_dev gauge
+app_reg_latency_std_dev{a="quote\"d\n",z="1"} 1
+# TYPE app_reg_ratio gauge
+app_reg_ratio{a="quote\"d\n",z="1"} 0.5
+# TYPE app_reg_requests_total counter
+app_reg_requests_total{a="quote\"d\n",z="1"} 3
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output :\n%s", b.String())
+	}
+}
+
+func TestFamiliesMerge(t *testing.T) {
+	var families []*family
+	for _, host := range []string{"b", "a"} {
+		r := metrics.NewRegistry()
+		metrics.NewRegisteredCounter("requests", r).Inc(1)
+		s := &section{name: "app_reg", registry: r, tags: map[string]string{"host": host}}
+		sectionFamilies, err := s.getFamilies(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		families = append(families, sectionFamilies...)
+	}
+
+	var b bytes.Buffer
+	writePrometheusText(&b, mergeFamilies(families))
+	expected := `# TYPE app_reg_requests_total counter
+app_reg_requests_total{host="a"} 1
+app_reg_requests_total{host="b"} 1
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output :\n%s", b.String())
+	}
+}
+
+func TestFamiliesMetadata(t *testing.T) {
+	c := metrics.NewCounter()
+	fams, err := familiesFromMetric("app_requests", c, driver.Metadata{Help: "Requests\nserved", Unit: "requests"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	writePrometheusText(&b, fams)
+	expected := `# HELP app_requests_total Requests\nserved
+# TYPE app_requests_total counter
+# UNIT app_requests_total requests
+app_requests_total 0
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output :\n%s", b.String())
+	}
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	c := metrics.NewCounter()
+	c.Inc(2)
+	h := histogram.NewBucketed([]float64{10})
+	h.Update(5)
+	created := time.Unix(1600000000, 500000000)
+
+	var families []*family
+	for name, metric := range map[string]interface{}{"app_sent": c, "app_latency": h} {
+		fams, err := familiesFromMetric(name, metric, driver.Metadata{Unit: "bytes", Tags: map[string]string{"host": "a"}}, created)
+		if err != nil {
+			t.Fatal(err)
+		}
+		families = append(families, fams...)
+	}
+
+	var b bytes.Buffer
+	if err := writeOpenMetrics(&b, mergeFamilies(families)); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# TYPE app_latency_bytes histogram
+# UNIT app_latency_bytes bytes
+app_latency_bytes_bucket{host="a",le="10"} 1
+app_latency_bytes_bucket{host="a",le="+Inf"} 1
+app_latency_bytes_sum{host="a"} 5
+app_latency_bytes_count{host="a"} 1
+app_latency_bytes_created{host="a"} 1.6000000005e+09
+# TYPE app_sent_bytes counter
+# UNIT app_sent_bytes bytes
+app_sent_bytes_total{host="a"} 2
+app_sent_bytes_created{host="a"} 1.6000000005e+09
+# EOF
+`
+	if b.String() != expected {
+		t.Fatalf("unexpected output :\n%s", b.String())
+	}
+
+	// The created timestamps are not part of the Prometheus text format
+	b.Reset()
+	writePrometheusText(&b, mergeFamilies(families))
+	if strings.Contains(b.String(), "_created") {
+		t.Fatalf("unexpected created timestamps :\n%s", b.String())
+	}
+}
+
+// plainSnapshotBucketed is a histogram.Bucketed whose snapshot is a plain metrics.Histogram
+type plainSnapshotBucketed struct {
+	histogram.Bucketed
+}
+
+func (h plainSnapshotBucketed) Snapshot() metrics.Histogram {
+	return metrics.NewHistogram(metrics.NewUniformSample(10)).Snapshot()
+}
+
+func TestFamiliesFromBucketedWithPlainSnapshot(t *testing.T) {
+	h := plainSnapshotBucketed{histogram.NewBucketed([]float64{10})}
+	fams, err := familiesFromMetric("app_latency", h, driver.Metadata{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fams) == 0 || fams[0].Type != typeSummary {
+		t.Fatalf("expected the histogram to be exposed as a summary and got %+v", fams)
+	}
+}
+
+func TestFamiliesFromTimer(t *testing.T) {
+	tm := metrics.NewTimer()
+	tm.Update(time.Second)
+	tm.Update(3 * time.Second)
+
+	families, err := familiesFromMetric("app_latency", tm, driver.Metadata{Help: "Latency of the requests."}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := writeOpenMetrics(&b, mergeFamilies(families)); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"# TYPE app_latency_seconds summary\n# UNIT app_latency_seconds seconds\n# HELP app_latency_seconds Latency of the requests.\n",
+		`app_latency_seconds{quantile="0.5"} 2` + "\n",
+		"app_latency_seconds_sum 4\n",
+		"app_latency_seconds_count 2\n",
+		"# TYPE app_latency_max_seconds gauge\n# UNIT app_latency_max_seconds seconds\napp_latency_max_seconds 3\n",
+		"app_latency_min_seconds 1\n",
+		"app_latency_mean_seconds 2\n",
+		"app_latency_std_dev_seconds 1\n",
+		"# TYPE app_latency_one_minute gauge\n",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Fatalf("expected %q in :\n%s", expected, b.String())
+		}
+	}
+}
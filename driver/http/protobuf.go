@@ -0,0 +1,194 @@
+package http
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Types of the io.prometheus.client.MetricFamily message
+const (
+	protoCounter   = 0
+	protoGauge     = 1
+	protoSummary   = 2
+	protoHistogram = 4
+)
+
+var protoTypes = map[string]uint64{
+	typeCounter:   protoCounter,
+	typeGauge:     protoGauge,
+	typeSummary:   protoSummary,
+	typeHistogram: protoHistogram,
+}
+
+// writeProtobuf encodes the families as length-delimited io.prometheus.client.MetricFamily
+// messages. The messages are written by hand to avoid depending on the protobuf libraries.
+func writeProtobuf(w io.Writer, families []*family) error {
+	var buf []byte
+	for _, fam := range families {
+		msg := encodeFamily(fam)
+		buf = appendUvarint(buf[:0], uint64(len(msg)))
+		buf = append(buf, msg...)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeFamily encodes a MetricFamily, with one Metric for each group of samples. As in the
+// text formats, the counters are named with their _total suffix.
+func encodeFamily(fam *family) []byte {
+	name := fam.Name
+	if fam.Type == typeCounter {
+		name += "_total"
+	}
+
+	var b []byte
+	b = appendString(b, 1, name)
+	if fam.Help != "" {
+		b = appendString(b, 2, fam.Help)
+	}
+	b = appendVarintField(b, 3, protoTypes[fam.Type])
+
+	samples := fam.Samples
+	for len(samples) > 0 {
+		end := 1
+		for end < len(samples) && samples[end].group == samples[0].group {
+			end++
+		}
+		b = appendMessage(b, 4, encodeMetric(fam, samples[:end]))
+		samples = samples[end:]
+	}
+
+	if fam.Unit != "" {
+		b = appendString(b, 5, fam.Unit)
+	}
+	return b
+}
+
+// encodeMetric encodes a Metric from the samples of one metric of the family
+func encodeMetric(fam *family, samples []*sample) []byte {
+	var b []byte
+
+	// The labels are the ones of the metric, without the quantile and le labels of the samples
+	labels := samples[0].Labels
+	for _, name := range sortedLabelNames(labels) {
+		if (name == "quantile" && fam.Type == typeSummary) || (name == "le" && fam.Type == typeHistogram) {
+			continue
+		}
+		var pair []byte
+		pair = appendString(pair, 1, name)
+		pair = appendString(pair, 2, labels[name])
+		b = appendMessage(b, 1, pair)
+	}
+
+	var value []byte
+	var created *sample
+	for _, s := range samples {
+		switch s.Name {
+		case fam.Name + "_created":
+			created = s
+		case fam.Name + "_sum":
+			value = appendDouble(value, 2, s.Value)
+		case fam.Name + "_count":
+			value = appendVarintField(value, 1, uint64(s.Value))
+		case fam.Name + "_bucket":
+			// The +Inf bucket is implicit, as its count is the sample count
+			if s.Labels["le"] == "+Inf" {
+				continue
+			}
+			var bucket []byte
+			bucket = appendVarintField(bucket, 1, uint64(s.Value))
+			bucket = appendDouble(bucket, 2, parseBound(s.Labels["le"]))
+			value = appendMessage(value, 3, bucket)
+		case fam.Name:
+			if fam.Type == typeSummary {
+				var quantile []byte
+				quantile = appendDouble(quantile, 1, parseBound(s.Labels["quantile"]))
+				quantile = appendDouble(quantile, 2, s.Value)
+				value = appendMessage(value, 3, quantile)
+				continue
+			}
+			value = appendDouble(value, 1, s.Value)
+		case fam.Name + "_total":
+			value = appendDouble(value, 1, s.Value)
+		}
+	}
+
+	switch fam.Type {
+	case typeCounter:
+		if created != nil {
+			value = appendMessage(value, 3, encodeTimestamp(created.Value))
+		}
+		b = appendMessage(b, 3, value)
+	case typeGauge:
+		b = appendMessage(b, 2, value)
+	case typeSummary:
+		if created != nil {
+			value = appendMessage(value, 4, encodeTimestamp(created.Value))
+		}
+		b = appendMessage(b, 4, value)
+	case typeHistogram:
+		if created != nil {
+			value = appendMessage(value, 15, encodeTimestamp(created.Value))
+		}
+		b = appendMessage(b, 7, value)
+	}
+
+	return b
+}
+
+// encodeTimestamp encodes a google.protobuf.Timestamp from a unix time in seconds
+func encodeTimestamp(ts float64) []byte {
+	seconds := math.Floor(ts)
+	var b []byte
+	b = appendVarintField(b, 1, uint64(int64(seconds)))
+	if nanos := int64((ts - seconds) * 1e9); nanos > 0 {
+		b = appendVarintField(b, 2, uint64(nanos))
+	}
+	return b
+}
+
+func parseBound(s string) float64 {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var raw [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(raw[:], v)
+	return append(b, raw[:n]...)
+}
+
+func appendTag(b []byte, field int, wireType uint64) []byte {
+	return appendUvarint(b, uint64(field)<<3|wireType)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, 0)
+	return appendUvarint(b, v)
+}
+
+func appendDouble(b []byte, field int, v float64) []byte {
+	b = appendTag(b, field, 1)
+	var raw [8]byte
+	binary.LittleEndian.PutUint64(raw[:], math.Float64bits(v))
+	return append(b, raw[:]...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	b = appendTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendMessage(b []byte, field int, msg []byte) []byte {
+	b = appendTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(msg)))
+	return append(b, msg...)
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestWriteProtobuf(t *testing.T) {
+	g := metrics.NewGaugeFloat64()
+	g.Update(1.5)
+	c := metrics.NewCounter()
+	c.Inc(2)
+
+	for i, test := range []struct {
+		name     string
+		metric   interface{}
+		md       driver.Metadata
+		created  time.Time
+		expected []byte
+	}{
+		//0 gauge without labels
+		{"g", g, driver.Metadata{}, time.Time{}, []byte{
+			0x12,                        // length of the family
+			0x0a, 0x01, 'g', 0x18, 0x01, // name and type
+			0x22, 0x0b, 0x12, 0x09, 0x09, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f, // metric with a gauge
+		}},
+		//1 counter with a label and a created timestamp
+		{"c", c, driver.Metadata{Tags: map[string]string{"a": "b"}}, time.Unix(1, 500000000), []byte{
+			0x2a,                                                      // length of the family
+			0x0a, 0x07, 'c', '_', 't', 'o', 't', 'a', 'l', 0x18, 0x00, // name and type
+			0x22, 0x1d, // metric
+			0x0a, 0x06, 0x0a, 0x01, 'a', 0x12, 0x01, 'b', // label
+			0x1a, 0x13, 0x09, 0, 0, 0, 0, 0, 0, 0, 0x40, // counter value
+			0x1a, 0x08, 0x08, 0x01, 0x10, 0x80, 0xca, 0xb5, 0xee, 0x01, // created timestamp
+		}},
+	} {
+		fams, err := familiesFromMetric(test.name, test.metric, test.md, test.created)
+		if err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+
+		var b bytes.Buffer
+		if err := writeProtobuf(&b, fams); err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+		if !bytes.Equal(b.Bytes(), test.expected) {
+			t.Fatalf("test #%d failed : unexpected encoding %x", i, b.Bytes())
+		}
+	}
+}
+
+// protobufFamilyNames returns the names of the delimited MetricFamily messages
+func protobufFamilyNames(t *testing.T, b []byte) []string {
+	var names []string
+	for len(b) > 0 {
+		length, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < length {
+			t.Fatalf("invalid delimited message %x", b)
+		}
+		msg := b[n : n+int(length)]
+		b = b[n+int(length):]
+
+		for len(msg) > 0 {
+			key, n := binary.Uvarint(msg)
+			msg = msg[n:]
+			switch key & 7 {
+			case 0:
+				_, n = binary.Uvarint(msg)
+				msg = msg[n:]
+			case 1:
+				msg = msg[8:]
+			case 2:
+				size, n := binary.Uvarint(msg)
+				if key>>3 == 1 {
+					names = append(names, string(msg[n:n+int(size)]))
+				}
+				msg = msg[n+int(size):]
+			default:
+				t.Fatalf("unexpected wire type %d", key&7)
+			}
+		}
+	}
+	return names
+}
+
+func TestProtobufNamesMatchText(t *testing.T) {
+	c := metrics.NewCounter()
+	m := metrics.NewMeter()
+	h := metrics.NewHistogram(metrics.NewUniformSample(10))
+	g := metrics.NewGauge()
+
+	var families []*family
+	for name, metric := range map[string]interface{}{"app_requests": c, "app_events": m, "app_latency": h, "app_size": g} {
+		fams, err := familiesFromMetric(name, metric, driver.Metadata{}, time.Unix(1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		families = append(families, fams...)
+	}
+	families = mergeFamilies(families)
+
+	var text bytes.Buffer
+	if err := writePrometheusText(&text, families); err != nil {
+		t.Fatal(err)
+	}
+	var textNames []string
+	for _, line := range strings.Split(text.String(), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			textNames = append(textNames, strings.Fields(line)[2])
+		}
+	}
+
+	var pb bytes.Buffer
+	if err := writeProtobuf(&pb, families); err != nil {
+		t.Fatal(err)
+	}
+	if pbNames := protobufFamilyNames(t, pb.Bytes()); !reflect.DeepEqual(pbNames, textNames) {
+		t.Fatalf("protobuf families %v differ from the text ones %v", pbNames, textNames)
+	}
+}
@@ -0,0 +1,242 @@
+package http
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+// schemaVersion is the version of the JSON documents, incremented on each breaking change
+const schemaVersion = 1
+
+// legacySchema is the value of the schema query parameter selecting the previous JSON
+// shape, the values of the registries as returned by metrics.Registry.GetAll
+const legacySchema = "legacy"
+
+// document is the JSON document describing the metrics of sections
+type document struct {
+	Version   int            `json:"version"`
+	Timestamp time.Time      `json:"timestamp"`
+	Sections  []*jsonSection `json:"sections"`
+}
+
+// jsonSection describes the metrics of a section, sent at Timestamp
+type jsonSection struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Tags      map[string]string `json:"tags"`
+	Timestamp time.Time         `json:"timestamp"`
+	Metrics   []interface{}     `json:"metrics"`
+}
+
+// jsonMetric holds the fields common to all the metrics. The tags are the ones
+// specific to the metric, added to the ones of the section.
+type jsonMetric struct {
+	Name string            `json:"name"`
+	Type string            `json:"type"`
+	Help string            `json:"help,omitempty"`
+	Unit string            `json:"unit,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+type jsonCounter struct {
+	jsonMetric
+	Count int64 `json:"count"`
+}
+
+type jsonGauge struct {
+	jsonMetric
+	Value float64 `json:"value"`
+}
+
+type jsonHistogram struct {
+	jsonMetric
+	Count     int64              `json:"count"`
+	Sum       float64            `json:"sum"`
+	Min       float64            `json:"min"`
+	Max       float64            `json:"max"`
+	Mean      float64            `json:"mean"`
+	StdDev    float64            `json:"stddev"`
+	Quantiles map[string]float64 `json:"quantiles"`
+	// Buckets are the cumulative counts of a histogram.Bucketed
+	Buckets []jsonBucket `json:"buckets,omitempty"`
+}
+
+type jsonBucket struct {
+	UpperBound float64 `json:"le"`
+	Count      int64   `json:"count"`
+}
+
+type jsonRates struct {
+	Rate1    float64 `json:"rate1"`
+	Rate5    float64 `json:"rate5"`
+	Rate15   float64 `json:"rate15"`
+	RateMean float64 `json:"rate_mean"`
+}
+
+type jsonMeter struct {
+	jsonMetric
+	Count int64 `json:"count"`
+	jsonRates
+}
+
+// jsonTimer describes a timer, its durations being in seconds
+type jsonTimer struct {
+	jsonHistogram
+	jsonRates
+}
+
+type jsonHealthcheck struct {
+	jsonMetric
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// getSection returns the description of the metrics of the section matching the filter, with
+// the time of their values given
+func (s *section) getSection(id string, f *filter, at time.Time) *jsonSection {
+	s.m.RLock()
+	registry := s.registry
+	s.m.RUnlock()
+
+	js := &jsonSection{
+		ID:        id,
+		Name:      s.registryName,
+		Tags:      s.tags,
+		Timestamp: at,
+		Metrics:   []interface{}{},
+	}
+	if js.Tags == nil {
+		js.Tags = map[string]string{}
+	}
+	if registry == nil {
+		return js
+	}
+
+	type namedMetric struct {
+		name   string
+		metric interface{}
+	}
+	var named []namedMetric
+	driver.Each(registry, s.tags, func(name string, i interface{}, md driver.Metadata) {
+		if !f.matchMetric(name, s.exposedName(name), md.Tags) {
+			return
+		}
+
+		header := jsonMetric{Name: name, Help: md.Help, Unit: md.Unit, Tags: ownTags(s.tags, md.Tags)}
+		metric := metricToJSON(header, s.checked(name, i))
+		if metric == nil {
+			log.Errorf("Unknown metric type %T for metric '%s'", i, name)
+			return
+		}
+		named = append(named, namedMetric{name: name + "\x00" + labelsSignature(md.Tags), metric: metric})
+	})
+
+	sort.Slice(named, func(i, j int) bool {
+		return named[i].name < named[j].name
+	})
+	for _, n := range named {
+		js.Metrics = append(js.Metrics, n.metric)
+	}
+	return js
+}
+
+// metricToJSON returns the typed description of the metric, nil if its type is unknown
+func metricToJSON(header jsonMetric, i interface{}) interface{} {
+	switch metric := i.(type) {
+
+	case metrics.Counter:
+		header.Type = "counter"
+		return &jsonCounter{jsonMetric: header, Count: metric.Count()}
+
+	case metrics.Gauge:
+		header.Type = "gauge"
+		return &jsonGauge{jsonMetric: header, Value: float64(metric.Value())}
+
+	case metrics.GaugeFloat64:
+		header.Type = "gauge"
+		return &jsonGauge{jsonMetric: header, Value: metric.Value()}
+
+	case metrics.Histogram:
+		header.Type = "histogram"
+		h := metric.Snapshot()
+		jh := newJSONHistogram(header, h, h.Percentiles(quantiles), 1)
+		if b, ok := h.(histogram.Bucketed); ok {
+			counts := b.BucketCounts()
+			for idx, bound := range b.Buckets() {
+				jh.Buckets = append(jh.Buckets, jsonBucket{UpperBound: bound, Count: counts[idx]})
+			}
+		}
+		return jh
+
+	case metrics.Meter:
+		header.Type = "meter"
+		m := metric.Snapshot()
+		return &jsonMeter{jsonMetric: header, Count: m.Count(), jsonRates: jsonRates{m.Rate1(), m.Rate5(), m.Rate15(), m.RateMean()}}
+
+	case metrics.Timer:
+		header.Type = "timer"
+		header.Unit = "seconds"
+		t := metric.Snapshot()
+		jh := newJSONHistogram(header, t, t.Percentiles(quantiles), float64(time.Second))
+		return &jsonTimer{jsonHistogram: *jh, jsonRates: jsonRates{t.Rate1(), t.Rate5(), t.Rate15(), t.RateMean()}}
+
+	case metrics.Healthcheck:
+		header.Type = "healthcheck"
+		jh := &jsonHealthcheck{jsonMetric: header, Healthy: true}
+		if err := metric.Error(); err != nil {
+			jh.Healthy, jh.Error = false, err.Error()
+		}
+		return jh
+	}
+
+	return nil
+}
+
+// distribution is implemented by the histograms and the timers
+type distribution interface {
+	Count() int64
+	Sum() int64
+	Min() int64
+	Max() int64
+	Mean() float64
+	StdDev() float64
+}
+
+// newJSONHistogram describes the distribution, its values being divided by scale
+func newJSONHistogram(header jsonMetric, d distribution, ps []float64, scale float64) *jsonHistogram {
+	jh := &jsonHistogram{
+		jsonMetric: header,
+		Count:      d.Count(),
+		Sum:        float64(d.Sum()) / scale,
+		Min:        float64(d.Min()) / scale,
+		Max:        float64(d.Max()) / scale,
+		Mean:       d.Mean() / scale,
+		StdDev:     d.StdDev() / scale,
+		Quantiles:  make(map[string]float64, len(quantiles)),
+	}
+	for idx, q := range quantiles {
+		jh.Quantiles[strconv.FormatFloat(q, 'g', -1, 64)] = ps[idx] / scale
+	}
+	return jh
+}
+
+// ownTags returns the tags of the metric which are not the ones of its section
+func ownTags(sectionTags, tags map[string]string) map[string]string {
+	var own map[string]string
+	for k, v := range tags {
+		if sv, exists := sectionTags[k]; exists && sv == v {
+			continue
+		}
+		if own == nil {
+			own = map[string]string{}
+		}
+		own[k] = v
+	}
+	return own
+}
@@ -0,0 +1,100 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestSchema(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredCounter("requests", r).Inc(3)
+	metrics.NewRegisteredGaugeFloat64("load", r).Update(0.5)
+	metrics.NewRegisteredTimer("latency", r).Update(2 * time.Second)
+	r.Register("db", metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		h.Unhealthy(errors.New("down"))
+	}))
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r, Tags: map[string]string{"env": "prod"}}})
+
+	for _, path := range []string{"/sections/metrics", "/section/reg(env:prod)"} {
+		w := httptest.NewRecorder()
+		requested := time.Now()
+		hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if ct := w.Header().Get("Content-Type"); ct != jsonContentType {
+			t.Fatalf("unexpected content type %s for %s", ct, path)
+		}
+
+		var doc struct {
+			Version  int `json:"version"`
+			Sections []struct {
+				ID        string                   `json:"id"`
+				Name      string                   `json:"name"`
+				Tags      map[string]string        `json:"tags"`
+				Timestamp time.Time                `json:"timestamp"`
+				Metrics   []map[string]interface{} `json:"metrics"`
+			} `json:"sections"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid response %s : %s", w.Body.String(), err)
+		}
+		if doc.Version != schemaVersion || len(doc.Sections) != 1 {
+			t.Fatalf("unexpected document %s", w.Body.String())
+		}
+
+		s := doc.Sections[0]
+		if s.ID != "reg(env:prod)" || s.Name != "reg" || s.Tags["env"] != "prod" || s.Timestamp.IsZero() {
+			t.Fatalf("unexpected section %s", w.Body.String())
+		}
+		// In live mode, the values are read at the time of the request, not at the last flush
+		if s.Timestamp.Before(requested) {
+			t.Fatalf("unexpected section %s", w.Body.String())
+		}
+
+		byName := map[string]map[string]interface{}{}
+		for _, m := range s.Metrics {
+			byName[m["name"].(string)] = m
+		}
+		for name, expected := range map[string]map[string]interface{}{
+			"requests": {"type": "counter", "count": float64(3)},
+			"load":     {"type": "gauge", "value": 0.5},
+			"latency":  {"type": "timer", "unit": "seconds", "count": float64(1), "max": float64(2)},
+			"db":       {"type": "healthcheck", "healthy": false, "error": "down"},
+		} {
+			for k, v := range expected {
+				if byName[name][k] != v {
+					t.Fatalf("expected %s=%v for %s and got %v", k, v, name, byName[name][k])
+				}
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/section/reg(env:prod)?schema=legacy", nil))
+	var legacy map[string]map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &legacy); err != nil {
+		t.Fatalf("invalid response %s : %s", w.Body.String(), err)
+	}
+	if legacy["latency"]["max"] != float64(2*time.Second) {
+		t.Fatalf("unexpected legacy response %s", w.Body.String())
+	}
+}
+
+func TestOwnTags(t *testing.T) {
+	own := ownTags(map[string]string{"env": "prod", "dc": "gra"}, map[string]string{"env": "prod", "dc": "sbg", "route": "/"})
+	if len(own) != 2 || own["dc"] != "sbg" || own["route"] != "/" {
+		t.Fatalf("unexpected tags %v", own)
+	}
+	if own := ownTags(map[string]string{"env": "prod"}, map[string]string{"env": "prod"}); own != nil {
+		t.Fatalf("unexpected tags %v", own)
+	}
+}
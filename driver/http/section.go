@@ -3,31 +3,54 @@ package http
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/ybriffa/metrics/driver"
 )
 
-var nameReplacer = strings.NewReplacer("-", "_", ".", "_")
-
 type section struct {
-	name     string
-	registry metrics.Registry
-	tags     map[string]string
+	name         string
+	registryName string
+	registry     metrics.Registry
+	tags         map[string]string
+	// created is the time the registry was first sent to the driver
+	created time.Time
+	// updated is the time the registry was last sent to the driver
+	updated time.Time
+	// history holds the values of the metrics at the last flushes
+	history *history
+	// health runs the healthchecks of the registry
+	health *healthChecks
 
 	m sync.RWMutex
 }
 
+// record sets the time of the last send and adds the current values of the metrics to the history of the section
+func (s *section) record(now time.Time) {
+	s.m.Lock()
+	s.updated = now
+	s.m.Unlock()
+
+	m, err := s.getMetrics(nil)
+	if err != nil {
+		return
+	}
+	s.history.add(&snapshot{Time: now, Values: m.(map[string]map[string]interface{})})
+}
+
 func (s *section) setRegistry(registry metrics.Registry) {
 	s.m.Lock()
 	defer s.m.Unlock()
 
 	s.registry = registry
+	s.health.reset()
 }
 
-func (s *section) getMetrics() (interface{}, error) {
+// getMetrics returns the values of the metrics matching the filter
+func (s *section) getMetrics(f *filter) (interface{}, error) {
 	s.m.RLock()
 	registry := s.registry
 	s.m.RUnlock()
@@ -36,10 +59,29 @@ func (s *section) getMetrics() (interface{}, error) {
 		return nil, errors.New("nil registry")
 	}
 
-	return s.registry.GetAll(), nil
+	return s.filterValues(registry, s.getAll(registry), f), nil
+}
+
+// getAll returns the values of the metrics of the registry as metrics.Registry.GetAll does, the
+// healthchecks being run through the section
+func (s *section) getAll(registry metrics.Registry) map[string]map[string]interface{} {
+	checked := metrics.NewRegistry()
+	registry.Each(func(name string, i interface{}) {
+		checked.Register(name, s.checked(name, i))
+	})
+	return checked.GetAll()
 }
 
-func (s *section) getGTS() ([]*GTS, error) {
+// checked returns the metric, the healthchecks being replaced by their result
+func (s *section) checked(name string, i interface{}) interface{} {
+	if hc, ok := i.(metrics.Healthcheck); ok {
+		return s.health.check(name, hc)
+	}
+	return i
+}
+
+// getHistory returns the values of the metrics matching the filter at the flushes after the time given
+func (s *section) getHistory(since time.Time, f *filter) ([]*snapshot, error) {
 	s.m.RLock()
 	registry := s.registry
 	s.m.RUnlock()
@@ -48,23 +90,66 @@ func (s *section) getGTS() ([]*GTS, error) {
 		return nil, errors.New("nil registry")
 	}
 
-	series := []*GTS{}
-	now := time.Now().UnixNano() / int64(time.Millisecond)
-	var errs []error
-	registry.Each(func(name string, i interface{}) {
-		name = fmt.Sprintf("%s_%s", s.name, name)
-		name = nameReplacer.Replace(strings.ToLower(name))
-		newSeries, err := gtsFromMetric(name, i, now, s.tags)
+	snapshots := s.history.since(since)
+	if f == nil {
+		return snapshots, nil
+	}
+
+	filtered := make([]*snapshot, len(snapshots))
+	for i, snap := range snapshots {
+		values := make(map[string]map[string]interface{}, len(snap.Values))
+		for name, v := range snap.Values {
+			values[name] = v
+		}
+		filtered[i] = &snapshot{Time: snap.Time, Values: s.filterValues(registry, values, f)}
+	}
+	return filtered, nil
+}
+
+// filterValues removes from the values the metrics not matching the filter
+func (s *section) filterValues(registry metrics.Registry, all map[string]map[string]interface{}, f *filter) map[string]map[string]interface{} {
+	if f == nil {
+		return all
+	}
+
+	for name := range all {
+		md := driver.MetadataOf(registry, s.tags, name)
+		if !f.matchMetric(md.Name, s.exposedName(md.Name), md.Tags) {
+			delete(all, name)
+		}
+	}
+	return all
+}
+
+// getFamilies returns the families of the metrics matching the filter
+func (s *section) getFamilies(f *filter) ([]*family, error) {
+	s.m.RLock()
+	registry := s.registry
+	s.m.RUnlock()
+
+	if registry == nil {
+		return nil, errors.New("nil registry")
+	}
+
+	families := []*family{}
+	driver.Each(registry, s.tags, func(name string, i interface{}, md driver.Metadata) {
+		exposedName := s.exposedName(name)
+		if !f.matchMetric(name, exposedName, md.Tags) {
+			return
+		}
+		newFamilies, err := familiesFromMetric(exposedName, s.checked(name, i), md, s.created)
 		if err != nil {
-			errs = append(errs, err)
+			// The other metrics are still exposed
+			log.Error(err)
 			return
 		}
-		series = append(series, newSeries...)
+		families = append(families, newFamilies...)
 	})
 
-	if len(errs) > 0 {
-		return nil, errs[0]
-	}
+	return families, nil
+}
 
-	return series, nil
+// exposedName returns the name of the metric in the Prometheus formats
+func (s *section) exposedName(name string) string {
+	return sanitizeName(fmt.Sprintf("%s_%s", s.name, name))
 }
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"errors"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+// The read modes of the driver
+const (
+	// ModeLive serves the values of the metrics at the time of the request
+	ModeLive = "live"
+	// ModeSnapshot serves the values of the metrics at the last flush
+	ModeSnapshot = "snapshot"
+)
+
+var (
+	ErrUnknownMode     error = errors.New("unknown mode, must be live or snapshot")
+	ErrInvalidCacheTTL error = errors.New("invalid cache ttl, must be a positive duration")
+)
+
+// frozenRegistry is a copy of a registry holding snapshots of its metrics, with their metadata
+type frozenRegistry struct {
+	metrics.Registry
+	metadata map[string]driver.Metadata
+}
+
+// Metadata is the implementation of the driver.MetadataRegistry
+func (r *frozenRegistry) Metadata(name string) (driver.Metadata, bool) {
+	md, exists := r.metadata[name]
+	return md, exists
+}
+
+// freeze returns a copy of the registry whose metrics keep their current values
+func freeze(r metrics.Registry) metrics.Registry {
+	mr, hasMetadata := r.(driver.MetadataRegistry)
+	ret := &frozenRegistry{Registry: metrics.NewRegistry(), metadata: map[string]driver.Metadata{}}
+	r.Each(func(name string, i interface{}) {
+		ret.Register(name, freezeMetric(i))
+		if !hasMetadata {
+			return
+		}
+		if md, exists := mr.Metadata(name); exists {
+			ret.metadata[name] = md
+		}
+	})
+	return ret
+}
+
+// freezeMetric returns a snapshot of the metric. The healthchecks are replaced by their result.
+func freezeMetric(i interface{}) interface{} {
+	switch metric := i.(type) {
+	case metrics.Counter:
+		return metric.Snapshot()
+	case metrics.Gauge:
+		return metric.Snapshot()
+	case metrics.GaugeFloat64:
+		return metric.Snapshot()
+	case metrics.Histogram:
+		return metric.Snapshot()
+	case metrics.Meter:
+		return metric.Snapshot()
+	case metrics.Timer:
+		return metric.Snapshot()
+	case metrics.Healthcheck:
+		return runHealthcheck(metric)
+	}
+	return i
+}
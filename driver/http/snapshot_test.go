@@ -0,0 +1,144 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestModes(t *testing.T) {
+	for i, test := range []struct {
+		mode         string
+		expected     string
+		lastModified bool
+	}{
+		//0 live by default, the values read at the time of the request
+		{"", "app_reg_requests_total 4", false},
+		//1 live
+		{ModeLive, "app_reg_requests_total 4", false},
+		//2 snapshot, the values of the last flush
+		{ModeSnapshot, "app_reg_requests_total 3", true},
+	} {
+		hd, err := New(Options{Name: "app", Mode: test.mode})
+		if err != nil {
+			t.Fatalf("test #%d failed : %s", i, err)
+		}
+
+		r := metrics.NewRegistry()
+		c := metrics.NewRegisteredCounter("requests", r)
+		c.Inc(3)
+		hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+		c.Inc(1)
+
+		req := httptest.NewRequest("GET", "/sections/metrics", nil)
+		req.Header.Set("Accept", "text/plain; version=0.0.4")
+		w := httptest.NewRecorder()
+		hd.Handler().ServeHTTP(w, req)
+
+		if !strings.Contains(w.Body.String(), test.expected) {
+			t.Fatalf("test #%d failed : unexpected body %s", i, w.Body.String())
+		}
+		if lastModified := w.Header().Get("Last-Modified"); (lastModified != "") != test.lastModified {
+			t.Fatalf("test #%d failed : unexpected Last-Modified %q", i, lastModified)
+		}
+	}
+
+	if _, err := New(Options{Name: "app", Mode: "lazy"}); err != ErrUnknownMode {
+		t.Fatalf("expected ErrUnknownMode and got %v", err)
+	}
+}
+
+func TestLiveNotCached(t *testing.T) {
+	hd, err := New(Options{Name: "app", Mode: ModeLive})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+
+	scrape := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/sections/metrics", nil)
+		req.Header.Set("Accept", "text/plain; version=0.0.4")
+		w := httptest.NewRecorder()
+		hd.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	first := scrape()
+	if !strings.Contains(first.Body.String(), "app_reg_requests_total 0") {
+		t.Fatalf("unexpected body %s", first.Body.String())
+	}
+	c.Inc(1)
+	second := scrape()
+	if !strings.Contains(second.Body.String(), "app_reg_requests_total 1") {
+		t.Fatalf("expected the current value and got %s", second.Body.String())
+	}
+	if second.Header().Get("ETag") == first.Header().Get("ETag") {
+		t.Fatal("expected the ETag to change with the values")
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	r := metrics.NewRegistry()
+	c := metrics.NewRegisteredCounter("requests", r)
+	tm := metrics.NewRegisteredTimer("latency", r)
+	healthy := true
+	r.Register("db", metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		if healthy {
+			h.Healthy()
+			return
+		}
+		h.Unhealthy(errors.New("down"))
+	}))
+
+	c.Inc(1)
+	tm.Update(1)
+	frozen := freeze(r)
+	c.Inc(1)
+	tm.Update(1)
+	healthy = false
+
+	if count := frozen.Get("requests").(metrics.Counter).Count(); count != 1 {
+		t.Fatalf("expected the frozen count 1 and got %d", count)
+	}
+	if count := frozen.Get("latency").(metrics.Timer).Count(); count != 1 {
+		t.Fatalf("expected the frozen timer count 1 and got %d", count)
+	}
+	h := frozen.Get("db").(metrics.Healthcheck)
+	h.Check()
+	if h.Error() != nil {
+		t.Fatalf("expected the frozen healthcheck to be healthy and got %s", h.Error())
+	}
+}
+
+func TestSnapshotHealthz(t *testing.T) {
+	hd, err := New(Options{Name: "app", Mode: ModeSnapshot})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := metrics.NewRegistry()
+	var failure error
+	r.Register("db", metrics.NewHealthcheck(func(h metrics.Healthcheck) {
+		if failure != nil {
+			h.Unhealthy(failure)
+			return
+		}
+		h.Healthy()
+	}))
+	hd.Send([]*driver.Registry{{Name: "reg", Registry: r}})
+	failure = errors.New("down")
+
+	w := httptest.NewRecorder()
+	hd.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the status of the last flush and got %d : %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,145 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamKeepAlive is the interval of the comments sent to the clients of the stream between
+// the events, so the idle connections are not closed by the proxies
+const streamKeepAlive = 15 * time.Second
+
+// streams notifies the clients of /sections/stream each time the registries are sent
+type streams struct {
+	m           sync.Mutex
+	subscribers map[*subscription]struct{}
+	keepAlive   time.Duration
+}
+
+// subscription is a client of the stream. The channel receives the time of each send, and
+// done is closed when the driver stops, to end the stream.
+type subscription struct {
+	ch   chan time.Time
+	done chan struct{}
+}
+
+// streamEvent is the data of the events sent to the clients of the stream
+type streamEvent struct {
+	Time     time.Time              `json:"time"`
+	Sections map[string]interface{} `json:"sections"`
+}
+
+func newStreams() *streams {
+	return &streams{
+		subscribers: map[*subscription]struct{}{},
+		keepAlive:   streamKeepAlive,
+	}
+}
+
+// subscribe returns a subscription receiving the time of each send. A slow client only gets
+// the last send it missed, as the channel is buffered with a single value.
+func (s *streams) subscribe() *subscription {
+	sub := &subscription{ch: make(chan time.Time, 1), done: make(chan struct{})}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *streams) unsubscribe(sub *subscription) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.subscribers, sub)
+}
+
+// publish notifies all the subscribers of a send, without blocking on the slow ones
+func (s *streams) publish(t time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for sub := range s.subscribers {
+		select {
+		case <-sub.ch:
+		default:
+		}
+		sub.ch <- t
+	}
+}
+
+// close ends the streams of the current subscribers. The clients subscribing afterwards, once
+// the driver is started again, are streamed as usual.
+func (s *streams) close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for sub := range s.subscribers {
+		close(sub.done)
+	}
+	s.subscribers = map[*subscription]struct{}{}
+}
+
+// streamSections sends an event holding the metrics of the sections matching the filter
+// of the query each time the registries are sent, until the client disconnects. Comments are
+// sent periodically to keep the connection alive.
+func (hd *Driver) streamSections(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	filter, err := parseFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := hd.streams.subscribe()
+	defer hd.streams.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(hd.streams.keepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.done:
+			return
+		case <-keepAlive.C:
+			if _, err := w.Write([]byte(":\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		case t := <-sub.ch:
+			event := &streamEvent{Time: t, Sections: map[string]interface{}{}}
+			hd.rangeSections(filter, func(id string, section *section) bool {
+				metrics, err := section.getMetrics(filter)
+				if err == nil {
+					event.Sections[id] = metrics
+				}
+				return true
+			})
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				return
+			}
+			if _, err := w.Write([]byte("event: flush\ndata: " + string(data) + "\n\n")); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+func TestStreamSections(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(hd.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/sections/stream?section=db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+
+	waitSubscribed(t, hd)
+
+	db, api := metrics.NewRegistry(), metrics.NewRegistry()
+	metrics.NewRegisteredCounter("queries", db).Inc(2)
+	metrics.NewRegisteredCounter("requests", api)
+	hd.Send([]*driver.Registry{{Name: "db", Registry: db}, {Name: "api", Registry: api}})
+
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "event: flush\n" {
+		t.Fatalf("unexpected event %q", line)
+	}
+	line, _ := reader.ReadString('\n')
+	var event streamEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+		t.Fatalf("unexpected data %q : %s", line, err)
+	}
+	if len(event.Sections) != 1 || event.Sections["db()"] == nil {
+		t.Fatalf("unexpected sections %v", event.Sections)
+	}
+
+	// The stream ends when the driver stops
+	if err := hd.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	reader.ReadString('\n')
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Fatal("expected the stream to end")
+	}
+
+	// The driver streams again once restarted
+	resp, err = http.Get(srv.URL + "/sections/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	waitSubscribed(t, hd)
+	hd.Send([]*driver.Registry{{Name: "db", Registry: db}})
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != "event: flush\n" {
+		t.Fatalf("unexpected event after the restart %q", line)
+	}
+}
+
+func TestStreamKeepAlive(t *testing.T) {
+	hd, err := New(Options{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hd.streams.keepAlive = 10 * time.Millisecond
+	srv := httptest.NewServer(hd.Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/sections/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != ":\n" {
+		t.Fatalf("expected a keep-alive comment and got %q", line)
+	}
+}
+
+// waitSubscribed waits for a client to be subscribed to the stream
+func waitSubscribed(t *testing.T, hd *Driver) {
+	for i := 0; ; i++ {
+		hd.streams.m.Lock()
+		subscribed := len(hd.streams.subscribers) == 1
+		hd.streams.m.Unlock()
+		if subscribed {
+			return
+		}
+		if i == 100 {
+			t.Fatal("client not subscribed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -24,13 +24,13 @@ type LogrusSender struct {
 
 func (ls *LogrusSender) Send(registries []*driver.Registry) error {
 	for _, registry := range registries {
-		slog := ls.logger.WithField("registry", registry.Name)
+		rlog := ls.logger.WithField("registry", registry.Name)
 
-		for key, value := range registry.Tags {
-			slog = slog.WithField(key, value)
-		}
-
-		registry.Registry.Each(func(name string, i interface{}) {
+		registry.Each(func(name string, i interface{}, md driver.Metadata) {
+			slog := rlog
+			for key, value := range md.Tags {
+				slog = slog.WithField(key, value)
+			}
 			writeMetric(slog, name, i)
 		})
 	}
@@ -73,6 +73,14 @@ func writeMetric(slog *log.Entry, name string, i interface{}) {
 			fmt.Sprintf("%s.std-dev", name): t.StdDev(),
 		})
 
+	case metrics.Healthcheck:
+		metric.Check()
+		health := "healthy"
+		if err := metric.Error(); err != nil {
+			health = err.Error()
+		}
+		slog = slog.WithField(name, health)
+
 	default:
 		slog.Errorf("Unknown metric type %T for metric '%s'", i, name)
 		return
@@ -0,0 +1,81 @@
+package driver
+
+import (
+	"github.com/rcrowley/go-metrics"
+)
+
+// Metadata describes a metric of a registry beyond its value.
+type Metadata struct {
+	// Name is the name to expose the metric with, when it differs from
+	// the name it is registered with.
+	Name string
+	// Tags are the tags specific to the metric, added to the ones of the registry.
+	Tags map[string]string
+	// Help is the description of the metric.
+	Help string
+	// Unit is the unit of the values of the metric, such as `bytes` or `seconds`.
+	Unit string
+}
+
+// MetadataRegistry is implemented by the registries holding metadata about their metrics.
+type MetadataRegistry interface {
+	metrics.Registry
+	Metadata(name string) (Metadata, bool)
+}
+
+// Each calls f for each metric of the registry, with its metadata. The name given is the
+// one to expose the metric with, and the tags of the metadata are the ones of the
+// registry merged with the ones of the metric.
+func (r *Registry) Each(f func(string, interface{}, Metadata)) {
+	Each(r.Registry, r.Tags, f)
+}
+
+// Each calls f for each metric of the metrics.Registry, with its metadata, merging the tags given
+// with the ones of each metric.
+func Each(r metrics.Registry, tags map[string]string, f func(string, interface{}, Metadata)) {
+	r.Each(func(name string, i interface{}) {
+		md := MetadataOf(r, tags, name)
+		f(md.Name, i, md)
+	})
+}
+
+// MetadataOf returns the metadata of the metric registered with the name given, merging the tags
+// given with the ones of the metric. The name of the metadata is the name to expose the metric with.
+func MetadataOf(r metrics.Registry, tags map[string]string, name string) Metadata {
+	md := Metadata{Name: name, Tags: tags}
+	mr, hasMetadata := r.(MetadataRegistry)
+	if !hasMetadata {
+		return md
+	}
+
+	if metricMD, exists := mr.Metadata(name); exists {
+		md = metricMD
+		if md.Name == "" {
+			md.Name = name
+		}
+		md.Tags = mergeTags(tags, metricMD.Tags)
+	}
+	return md
+}
+
+// WithTag returns a copy of the tags with the one given added, such as the `le` tag of the buckets
+// of a histogram
+func WithTag(tags map[string]string, key, value string) map[string]string {
+	return mergeTags(tags, map[string]string{key: value})
+}
+
+// mergeTags returns the tags of the registry with the ones of the metric, which takes precedence
+func mergeTags(registryTags, metricTags map[string]string) map[string]string {
+	if len(metricTags) == 0 {
+		return registryTags
+	}
+
+	ret := make(map[string]string, len(registryTags)+len(metricTags))
+	for k, v := range registryTags {
+		ret[k] = v
+	}
+	for k, v := range metricTags {
+		ret[k] = v
+	}
+	return ret
+}
@@ -20,3 +20,9 @@ type Registry struct {
 type Driver interface {
 	Send([]*Registry) error
 }
+
+// Stopper is implemented by the drivers holding resources, such as a listener, to release
+// when the metrics are stopped.
+type Stopper interface {
+	Stop() error
+}
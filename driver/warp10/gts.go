@@ -1,6 +1,7 @@
 package warp10
 
 import (
+	"encoding/base64"
 	"fmt"
 	"math"
 	"net/url"
@@ -58,6 +59,9 @@ func (gts *GTS) Encode() []byte {
 	case string:
 		sensision += fmt.Sprintf("'%s'", url.QueryEscape(gts.Value.(string)))
 
+	case []byte:
+		sensision += "b64:" + base64.StdEncoding.EncodeToString(gts.Value.([]byte))
+
 	default:
 		// Other types: just output their default format
 		strVal := fmt.Sprintf("%v", gts.Value)
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/eapache/go-resiliency/retrier"
@@ -13,6 +14,7 @@ import (
 	"github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
 )
 
 const (
@@ -75,8 +77,8 @@ func (ws *Warp10Sender) Send(registries []*driver.Registry) error {
 
 	series := []*GTS{}
 	for _, registry := range registries {
-		registry.Registry.Each(func(name string, i interface{}) {
-			series = append(series, ws.writeMetric(fmt.Sprintf("%s.%s", registry.Name, name), i, float64(now), registry.Tags)...)
+		registry.Each(func(name string, i interface{}, md driver.Metadata) {
+			series = append(series, ws.writeMetric(fmt.Sprintf("%s.%s", registry.Name, name), i, float64(now), md.Tags)...)
 		})
 	}
 
@@ -145,6 +147,18 @@ func (ws *Warp10Sender) writeMetric(name string, i interface{}, now float64, tag
 
 	case metrics.Histogram:
 		h := metric.Snapshot()
+		// The bucket counts can be aggregated across instances, unlike the percentiles
+		if b, ok := h.(histogram.Bucketed); ok {
+			counts := b.BucketCounts()
+			for i, bound := range b.Buckets() {
+				m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.bucket", ws.Prefix, name), Ts: now, Value: counts[i], Labels: driver.WithTag(tags, "le", strconv.FormatFloat(bound, 'g', -1, 64))})
+			}
+			m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.bucket", ws.Prefix, name), Ts: now, Value: b.Count(), Labels: driver.WithTag(tags, "le", "+Inf")})
+			m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.sum", ws.Prefix, name), Ts: now, Value: b.Sum(), Labels: tags})
+			m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.count", ws.Prefix, name), Ts: now, Value: b.Count(), Labels: tags})
+			break
+		}
+
 		ps := h.Percentiles([]float64{0.5, 0.75, 0.95, 0.99, 0.999})
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.count", ws.Prefix, name), Ts: now, Value: h.Count(), Labels: tags})
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.min", ws.Prefix, name), Ts: now, Value: h.Min(), Labels: tags})
@@ -157,6 +171,16 @@ func (ws *Warp10Sender) writeMetric(name string, i interface{}, now float64, tag
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.99-percentile", ws.Prefix, name), Ts: now, Value: ps[3], Labels: tags})
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.999-percentile", ws.Prefix, name), Ts: now, Value: ps[4], Labels: tags})
 
+		// Push the sketch itself so it can be merged server side
+		if sketch, ok := h.(histogram.Sketch); ok {
+			data, err := sketch.MarshalBinary()
+			if err != nil {
+				log.Errorf("Failed to encode the sketch of metric '%s' : %s", name, err)
+				break
+			}
+			m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.sketch", ws.Prefix, name), Ts: now, Value: data, Labels: tags})
+		}
+
 	case metrics.Meter:
 		meter := metric.Snapshot()
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.count", ws.Prefix, name), Ts: now, Value: meter.Count(), Labels: tags})
@@ -183,9 +207,21 @@ func (ws *Warp10Sender) writeMetric(name string, i interface{}, now float64, tag
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.fifteen-minute", ws.Prefix, name), Ts: now, Value: t.Rate15(), Labels: tags})
 		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.mean-rate", ws.Prefix, name), Ts: now, Value: t.RateMean(), Labels: tags})
 
+	case metrics.Healthcheck:
+		metric.Check()
+		m = append(m, &GTS{Name: fmt.Sprintf("%s.%s.healthy", ws.Prefix, name), Ts: now, Value: healthValue(metric), Labels: tags})
+
 	default:
 		log.Errorf("Unknown metric type %T for metric '%s'", i, name)
 	}
 
 	return m
 }
+
+// healthValue returns 1 if the healthcheck is healthy, 0 otherwise
+func healthValue(hc metrics.Healthcheck) int64 {
+	if hc.Error() != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+var (
+	ErrMissingLabel        error = errors.New("missing metrics_label tag on family")
+	ErrFamilyNotRegistered error = errors.New("family not registered by RegistryFromStruct")
+)
+
+// Family is a set of metrics registered with the same name, each one tagged with a different
+// value of a label. It is declared in a struct given to RegistryFromStruct through one of the
+// typed families, such as
+//
+//	ByStatus *metrics.CounterFamily `metrics:"requests" metrics_label:"status"`
+//
+// The metrics are created and registered the first time their label value is used.
+type Family struct {
+	m        sync.Mutex
+	name     string
+	label    string
+	tag      reflect.StructTag
+	md       driver.Metadata
+	registry *metadataRegistry
+	metrics  map[string]interface{}
+}
+
+// labeledFamily is implemented by the typed families
+type labeledFamily interface {
+	family() *Family
+	// metricType is the type of the metrics of the family, such as metrics.Counter
+	metricType() reflect.Type
+}
+
+func (f *Family) family() *Family { return f }
+
+// with returns the metric of the label value, creating and registering it if needed
+func (f *Family) with(value string, metricType reflect.Type) (interface{}, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.registry == nil {
+		return nil, ErrFamilyNotRegistered
+	}
+
+	if i, exists := f.metrics[value]; exists {
+		return i, nil
+	}
+
+	i, err := metricFromField(reflect.Zero(metricType), f.tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.register(value, i); err != nil {
+		return nil, err
+	}
+	f.metrics[value] = i
+	return i, nil
+}
+
+func (f *Family) register(value string, i interface{}) error {
+	md := f.md
+	md.Name = f.name
+	md.Tags = map[string]string{f.label: value}
+	for k, v := range f.md.Tags {
+		md.Tags[k] = v
+	}
+	if err := f.registry.registerWithMetadata(f.metricName(value), i, md); err != nil {
+		return err
+	}
+	if f.tag.Get("metrics_reset") == "flush" {
+		f.registry.setResetOnFlush(f.metricName(value))
+	}
+	return nil
+}
+
+func (f *Family) metricName(value string) string {
+	return fmt.Sprintf("%s[%s=%s]", f.name, f.label, value)
+}
+
+// CounterFamily is a Family of metrics.Counter
+type CounterFamily struct{ Family }
+
+// With returns the counter of the label value, created and registered the first time it is used
+func (f *CounterFamily) With(value string) (metrics.Counter, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.Counter), nil
+}
+
+func (f *CounterFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.Counter)(nil)).Elem()
+}
+
+// GaugeFamily is a Family of metrics.Gauge
+type GaugeFamily struct{ Family }
+
+// With returns the gauge of the label value, created and registered the first time it is used
+func (f *GaugeFamily) With(value string) (metrics.Gauge, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.Gauge), nil
+}
+
+func (f *GaugeFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.Gauge)(nil)).Elem()
+}
+
+// GaugeFloat64Family is a Family of metrics.GaugeFloat64
+type GaugeFloat64Family struct{ Family }
+
+// With returns the gauge of the label value, created and registered the first time it is used
+func (f *GaugeFloat64Family) With(value string) (metrics.GaugeFloat64, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.GaugeFloat64), nil
+}
+
+func (f *GaugeFloat64Family) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.GaugeFloat64)(nil)).Elem()
+}
+
+// MeterFamily is a Family of metrics.Meter
+type MeterFamily struct{ Family }
+
+// With returns the meter of the label value, created and registered the first time it is used
+func (f *MeterFamily) With(value string) (metrics.Meter, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.Meter), nil
+}
+
+func (f *MeterFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.Meter)(nil)).Elem()
+}
+
+// TimerFamily is a Family of metrics.Timer
+type TimerFamily struct{ Family }
+
+// With returns the timer of the label value, created and registered the first time it is used
+func (f *TimerFamily) With(value string) (metrics.Timer, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.Timer), nil
+}
+
+func (f *TimerFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.Timer)(nil)).Elem()
+}
+
+// HistogramFamily is a Family of metrics.Histogram, configured by the `metrics_sample:""` tags
+type HistogramFamily struct{ Family }
+
+// With returns the histogram of the label value, created and registered the first time it is used
+func (f *HistogramFamily) With(value string) (metrics.Histogram, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(metrics.Histogram), nil
+}
+
+func (f *HistogramFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*metrics.Histogram)(nil)).Elem()
+}
+
+// BucketedFamily is a Family of histogram.Bucketed, configured by the `metrics_buckets:""` tags
+type BucketedFamily struct{ Family }
+
+// With returns the histogram of the label value, created and registered the first time it is used
+func (f *BucketedFamily) With(value string) (histogram.Bucketed, error) {
+	i, err := f.with(value, f.metricType())
+	if err != nil {
+		return nil, err
+	}
+	return i.(histogram.Bucketed), nil
+}
+
+func (f *BucketedFamily) metricType() reflect.Type {
+	return reflect.TypeOf((*histogram.Bucketed)(nil)).Elem()
+}
+
+var labeledFamilyType = reflect.TypeOf((*labeledFamily)(nil)).Elem()
+
+// isFamily returns whether the type of the field is a typed family, by value or by pointer
+func isFamily(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		return t.Implements(labeledFamilyType)
+	}
+	return reflect.PtrTo(t).Implements(labeledFamilyType)
+}
+
+// registerFamily attaches the family of the field v to the registry, allocating it if needed.
+// The metrics it already holds, from a previous registration, are dropped.
+func registerFamily(r *metadataRegistry, name string, v reflect.Value, tag reflect.StructTag, md driver.Metadata) error {
+	label := tag.Get("metrics_label")
+	if label == "" {
+		return ErrMissingLabel
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+	} else {
+		v = v.Addr()
+	}
+	lf := v.Interface().(labeledFamily)
+
+	// Make sure the metrics of the family can be instantiated before accepting it
+	if _, err := metricFromField(reflect.Zero(lf.metricType()), tag); err != nil {
+		return err
+	}
+
+	f := lf.family()
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.name = name
+	f.label = label
+	f.tag = tag
+	f.md = md
+	f.registry = r
+	f.metrics = map[string]interface{}{}
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/dimfeld/httptreemux/v5 v5.3.0
 	github.com/eapache/go-resiliency v1.2.0
+	github.com/klauspost/compress v1.11.13
 	github.com/ovh/configstore v0.5.0
 	github.com/rcrowley/go-metrics v0.0.0-20201227073835-cf1acfcdf475
 	github.com/sirupsen/logrus v1.8.1
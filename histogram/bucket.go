@@ -0,0 +1,289 @@
+package histogram
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// DefaultBuckets are the upper bounds used by NewBucketed when no bucket is given.
+var DefaultBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Bucketed is a metrics.Histogram counting the values in fixed buckets. Unlike the
+// reservoir-sampled histograms, the bucket counts of several instances can be summed
+// to compute aggregated percentiles.
+type Bucketed interface {
+	metrics.Histogram
+
+	// Buckets returns the upper bounds of the buckets, in increasing order.
+	// The +Inf bucket is implicit and not part of the returned slice.
+	Buckets() []float64
+
+	// BucketCounts returns the cumulative counts of the values lower or equal
+	// to each upper bound returned by Buckets.
+	BucketCounts() []int64
+}
+
+// LinearBuckets returns count buckets, the first one having start as upper bound
+// and each following one being width larger than the previous.
+func LinearBuckets(start, width float64, count int) []float64 {
+	buckets := make([]float64, 0, count)
+	for i := 0; i < count; i++ {
+		buckets = append(buckets, start+float64(i)*width)
+	}
+	return buckets
+}
+
+// ExponentialBuckets returns count buckets, the first one having start as upper bound
+// and each following one being factor times larger than the previous.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, 0, count)
+	for i := 0; i < count; i++ {
+		buckets = append(buckets, start)
+		start *= factor
+	}
+	return buckets
+}
+
+// NewBucketed creates a Bucketed histogram with the given upper bounds.
+// DefaultBuckets are used if no bound is given.
+func NewBucketed(buckets []float64) Bucketed {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	// Copy the buckets to not depend on the slice of the caller, then deduplicate them
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	bounds := sorted[:0]
+	for i, b := range sorted {
+		if i > 0 && b == sorted[i-1] {
+			continue
+		}
+		bounds = append(bounds, b)
+	}
+
+	return &StandardBucketed{
+		state: bucketState{
+			bounds: bounds,
+			counts: make([]int64, len(bounds)+1),
+		},
+	}
+}
+
+// StandardBucketed is the standard implementation of a Bucketed histogram.
+type StandardBucketed struct {
+	state bucketState
+	m     sync.Mutex
+}
+
+// Buckets returns the upper bounds of the buckets.
+func (h *StandardBucketed) Buckets() []float64 {
+	return h.state.bounds
+}
+
+// BucketCounts returns the cumulative counts of each bucket.
+func (h *StandardBucketed) BucketCounts() []int64 {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	return h.state.cumulativeCounts()
+}
+
+// Clear resets all the buckets.
+func (h *StandardBucketed) Clear() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.state = bucketState{
+		bounds: h.state.bounds,
+		counts: make([]int64, len(h.state.bounds)+1),
+	}
+}
+
+// Count returns the number of values recorded.
+func (h *StandardBucketed) Count() int64 { return h.Snapshot().Count() }
+
+// Max returns the maximal value recorded.
+func (h *StandardBucketed) Max() int64 { return h.Snapshot().Max() }
+
+// Mean returns the mean of the values recorded.
+func (h *StandardBucketed) Mean() float64 { return h.Snapshot().Mean() }
+
+// Min returns the minimal value recorded.
+func (h *StandardBucketed) Min() int64 { return h.Snapshot().Min() }
+
+// Percentile returns an estimation of the given percentile, interpolated from the buckets.
+func (h *StandardBucketed) Percentile(p float64) float64 { return h.Snapshot().Percentile(p) }
+
+// Percentiles returns an estimation of the given percentiles, interpolated from the buckets.
+func (h *StandardBucketed) Percentiles(ps []float64) []float64 { return h.Snapshot().Percentiles(ps) }
+
+// Sample returns a NilSample, the values are not kept by a Bucketed histogram.
+func (h *StandardBucketed) Sample() metrics.Sample { return metrics.NilSample{} }
+
+// Snapshot returns a read-only copy of the histogram.
+func (h *StandardBucketed) Snapshot() metrics.Histogram {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	state := h.state
+	state.counts = append([]int64{}, h.state.counts...)
+	return &BucketedSnapshot{state: state}
+}
+
+// StdDev returns the standard deviation of the values recorded.
+func (h *StandardBucketed) StdDev() float64 { return h.Snapshot().StdDev() }
+
+// Sum returns the sum of the values recorded.
+func (h *StandardBucketed) Sum() int64 { return h.Snapshot().Sum() }
+
+// Update records a new value.
+func (h *StandardBucketed) Update(v int64) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.state.update(v)
+}
+
+// Variance returns the variance of the values recorded.
+func (h *StandardBucketed) Variance() float64 { return h.Snapshot().Variance() }
+
+// BucketedSnapshot is a read-only copy of a Bucketed histogram.
+type BucketedSnapshot struct {
+	state bucketState
+}
+
+// Buckets returns the upper bounds of the buckets.
+func (h *BucketedSnapshot) Buckets() []float64 { return h.state.bounds }
+
+// BucketCounts returns the cumulative counts of each bucket.
+func (h *BucketedSnapshot) BucketCounts() []int64 { return h.state.cumulativeCounts() }
+
+// Clear panics.
+func (*BucketedSnapshot) Clear() {
+	panic("Clear called on a BucketedSnapshot")
+}
+
+// Count returns the number of values recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Count() int64 { return h.state.count }
+
+// Max returns the maximal value recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Max() int64 { return h.state.max }
+
+// Mean returns the mean of the values recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Mean() float64 {
+	if h.state.count == 0 {
+		return 0
+	}
+	return float64(h.state.sum) / float64(h.state.count)
+}
+
+// Min returns the minimal value recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Min() int64 { return h.state.min }
+
+// Percentile returns an estimation of the given percentile at the time the snapshot was taken.
+func (h *BucketedSnapshot) Percentile(p float64) float64 {
+	return h.state.percentile(p)
+}
+
+// Percentiles returns an estimation of the given percentiles at the time the snapshot was taken.
+func (h *BucketedSnapshot) Percentiles(ps []float64) []float64 {
+	values := make([]float64, len(ps))
+	for i, p := range ps {
+		values[i] = h.state.percentile(p)
+	}
+	return values
+}
+
+// Sample returns a NilSample, the values are not kept by a Bucketed histogram.
+func (h *BucketedSnapshot) Sample() metrics.Sample { return metrics.NilSample{} }
+
+// Snapshot returns the snapshot.
+func (h *BucketedSnapshot) Snapshot() metrics.Histogram { return h }
+
+// StdDev returns the standard deviation of the values recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) StdDev() float64 { return math.Sqrt(h.Variance()) }
+
+// Sum returns the sum of the values recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Sum() int64 { return h.state.sum }
+
+// Update panics.
+func (*BucketedSnapshot) Update(int64) {
+	panic("Update called on a BucketedSnapshot")
+}
+
+// Variance returns the variance of the values recorded at the time the snapshot was taken.
+func (h *BucketedSnapshot) Variance() float64 {
+	if h.state.count == 0 {
+		return 0
+	}
+	mean := h.Mean()
+	return h.state.sumSquares/float64(h.state.count) - mean*mean
+}
+
+// bucketState holds the values shared by StandardBucketed and BucketedSnapshot.
+// counts are not cumulative and have one more entry than bounds for the +Inf bucket.
+type bucketState struct {
+	bounds     []float64
+	counts     []int64
+	count      int64
+	sum        int64
+	sumSquares float64
+	min, max   int64
+}
+
+func (s *bucketState) update(v int64) {
+	idx := sort.SearchFloat64s(s.bounds, float64(v))
+	s.counts[idx]++
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.sumSquares += float64(v) * float64(v)
+}
+
+func (s *bucketState) cumulativeCounts() []int64 {
+	counts := make([]int64, len(s.bounds))
+	var total int64
+	for i := range s.bounds {
+		total += s.counts[i]
+		counts[i] = total
+	}
+	return counts
+}
+
+// percentile interpolates linearly the percentile p in the bucket containing it.
+// The bounds of the first and the last buckets are narrowed to the min and max values.
+func (s *bucketState) percentile(p float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := p * float64(s.count)
+	var total int64
+	for i, c := range s.counts {
+		if c == 0 || float64(total+c) < rank {
+			total += c
+			continue
+		}
+
+		lower, upper := float64(s.min), float64(s.max)
+		if i > 0 && s.bounds[i-1] > lower {
+			lower = s.bounds[i-1]
+		}
+		if i < len(s.bounds) && s.bounds[i] < upper {
+			upper = s.bounds[i]
+		}
+		return lower + (upper-lower)*(rank-float64(total))/float64(c)
+	}
+
+	return float64(s.max)
+}
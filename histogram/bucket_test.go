@@ -0,0 +1,44 @@
+package histogram
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	linear := LinearBuckets(0, 10, 3)
+	if !reflect.DeepEqual(linear, []float64{0, 10, 20}) {
+		t.Fatalf("unexpected linear buckets %v", linear)
+	}
+
+	exp := ExponentialBuckets(1, 2, 4)
+	if !reflect.DeepEqual(exp, []float64{1, 2, 4, 8}) {
+		t.Fatalf("unexpected exponential buckets %v", exp)
+	}
+}
+
+func TestBucketed(t *testing.T) {
+	h := NewBucketed([]float64{10, 20, 30})
+	for i := int64(1); i <= 40; i++ {
+		h.Update(i)
+	}
+
+	s := h.Snapshot().(Bucketed)
+	if counts := s.BucketCounts(); !reflect.DeepEqual(counts, []int64{10, 20, 30}) {
+		t.Fatalf("unexpected bucket counts %v", counts)
+	}
+	if s.Count() != 40 || s.Sum() != 820 || s.Min() != 1 || s.Max() != 40 {
+		t.Fatalf("unexpected count %d, sum %d, min %d or max %d", s.Count(), s.Sum(), s.Min(), s.Max())
+	}
+	if p := s.Percentile(0.5); p != 20 {
+		t.Fatalf("expected median 20 and got %f", p)
+	}
+	if p := s.Percentile(0.25); p != 10 {
+		t.Fatalf("expected 25th percentile 10 and got %f", p)
+	}
+
+	h.Clear()
+	if h.Count() != 0 || h.BucketCounts()[2] != 0 {
+		t.Fatal("histogram not cleared")
+	}
+}
@@ -0,0 +1,280 @@
+package histogram
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// SlidingWindowSample is a metrics.Sample keeping the last values recorded, so the
+// statistics reflect the recent behaviour rather than the whole process lifetime.
+type SlidingWindowSample struct {
+	count  int64
+	values []int64
+	// next is the index of the oldest value, overwritten by the next update once the window is full
+	next int
+	m    sync.Mutex
+}
+
+// NewSlidingWindowSample creates a sample keeping the last size values.
+func NewSlidingWindowSample(size int) metrics.Sample {
+	return &SlidingWindowSample{
+		values: make([]int64, 0, size),
+	}
+}
+
+// Clear clears all the values.
+func (s *SlidingWindowSample) Clear() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.count = 0
+	s.values = s.values[:0]
+	s.next = 0
+}
+
+// Count returns the number of values recorded, which may exceed the size of the window.
+func (s *SlidingWindowSample) Count() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.count
+}
+
+// Max returns the maximal value in the window.
+func (s *SlidingWindowSample) Max() int64 { return s.Snapshot().Max() }
+
+// Mean returns the mean of the values in the window.
+func (s *SlidingWindowSample) Mean() float64 { return s.Snapshot().Mean() }
+
+// Min returns the minimal value in the window.
+func (s *SlidingWindowSample) Min() int64 { return s.Snapshot().Min() }
+
+// Percentile returns the given percentile of the values in the window.
+func (s *SlidingWindowSample) Percentile(p float64) float64 { return s.Snapshot().Percentile(p) }
+
+// Percentiles returns the given percentiles of the values in the window.
+func (s *SlidingWindowSample) Percentiles(ps []float64) []float64 {
+	return s.Snapshot().Percentiles(ps)
+}
+
+// Size returns the number of values in the window.
+func (s *SlidingWindowSample) Size() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.values)
+}
+
+// Snapshot returns a read-only copy of the sample.
+func (s *SlidingWindowSample) Snapshot() metrics.Sample {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return metrics.NewSampleSnapshot(s.count, append([]int64{}, s.values...))
+}
+
+// StdDev returns the standard deviation of the values in the window.
+func (s *SlidingWindowSample) StdDev() float64 { return s.Snapshot().StdDev() }
+
+// Sum returns the sum of the values in the window.
+func (s *SlidingWindowSample) Sum() int64 { return s.Snapshot().Sum() }
+
+// Update records a new value, replacing the oldest one if the window is full.
+func (s *SlidingWindowSample) Update(v int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.count++
+	if len(s.values) < cap(s.values) {
+		s.values = append(s.values, v)
+		return
+	}
+	if len(s.values) == 0 {
+		return
+	}
+	s.values[s.next] = v
+	s.next = (s.next + 1) % len(s.values)
+}
+
+// Values returns a copy of the values in the window.
+func (s *SlidingWindowSample) Values() []int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]int64{}, s.values...)
+}
+
+// Variance returns the variance of the values in the window.
+func (s *SlidingWindowSample) Variance() float64 { return s.Snapshot().Variance() }
+
+// Size of the reservoir of a TimeWindowSample, split between its buckets
+const (
+	timeWindowBuckets       = 10
+	timeWindowReservoirSize = 1030
+)
+
+// TimeWindowSample is a metrics.Sample keeping the values recorded during the last
+// duration. The window is split in time slices, whose values expire together : the
+// values kept are the ones of the slices overlapping the last duration. Each slice keeps
+// a bounded uniform sample of its values, so the memory used does not depend on the rate.
+type TimeWindowSample struct {
+	// width is the duration of a slice
+	width   time.Duration
+	count   int64
+	buckets []timeBucket
+	m       sync.Mutex
+
+	// now is replaced in the tests
+	now func() time.Time
+}
+
+// timeBucket holds the values of a time slice
+type timeBucket struct {
+	// slot is the index of the slice since the epoch
+	slot int64
+	// count is the number of values recorded in the slice, which may exceed the size of values
+	count  int64
+	values []int64
+}
+
+// NewTimeWindowSample creates a sample keeping the values recorded during the last window.
+func NewTimeWindowSample(window time.Duration) metrics.Sample {
+	width := window / timeWindowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &TimeWindowSample{
+		width:   width,
+		buckets: make([]timeBucket, timeWindowBuckets),
+		now:     time.Now,
+	}
+}
+
+// Clear clears all the values.
+func (s *TimeWindowSample) Clear() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.count = 0
+	for i := range s.buckets {
+		s.buckets[i] = timeBucket{}
+	}
+}
+
+// Count returns the number of values recorded, which may exceed the number of values in the window.
+func (s *TimeWindowSample) Count() int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.count
+}
+
+// Max returns the maximal value in the window.
+func (s *TimeWindowSample) Max() int64 { return s.Snapshot().Max() }
+
+// Mean returns the mean of the values in the window.
+func (s *TimeWindowSample) Mean() float64 { return s.Snapshot().Mean() }
+
+// Min returns the minimal value in the window.
+func (s *TimeWindowSample) Min() int64 { return s.Snapshot().Min() }
+
+// Percentile returns the given percentile of the values in the window.
+func (s *TimeWindowSample) Percentile(p float64) float64 { return s.Snapshot().Percentile(p) }
+
+// Percentiles returns the given percentiles of the values in the window.
+func (s *TimeWindowSample) Percentiles(ps []float64) []float64 {
+	return s.Snapshot().Percentiles(ps)
+}
+
+// Size returns the number of values in the window.
+func (s *TimeWindowSample) Size() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	size := 0
+	current := s.slot()
+	for i := range s.buckets {
+		if s.buckets[i].live(current) {
+			size += len(s.buckets[i].values)
+		}
+	}
+	return size
+}
+
+// Snapshot returns a read-only copy of the sample.
+func (s *TimeWindowSample) Snapshot() metrics.Sample {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return metrics.NewSampleSnapshot(s.count, s.copyValues())
+}
+
+// StdDev returns the standard deviation of the values in the window.
+func (s *TimeWindowSample) StdDev() float64 { return s.Snapshot().StdDev() }
+
+// Sum returns the sum of the values in the window.
+func (s *TimeWindowSample) Sum() int64 { return s.Snapshot().Sum() }
+
+// Update records a new value. Once the sample of its slice is full, it replaces a random
+// value of the slice with a probability decreasing with the number of values of the slice.
+func (s *TimeWindowSample) Update(v int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.count++
+
+	slot := s.slot()
+	b := &s.buckets[int(slot%int64(len(s.buckets)))]
+	if b.slot != slot {
+		// The bucket holds an expired slice, it is reused
+		b.slot = slot
+		b.count = 0
+		b.values = b.values[:0]
+	}
+
+	b.count++
+	if len(b.values) < timeWindowReservoirSize/timeWindowBuckets {
+		b.values = append(b.values, v)
+		return
+	}
+	if r := rand.Int63n(b.count); r < int64(len(b.values)) {
+		b.values[r] = v
+	}
+}
+
+// Values returns a copy of the values in the window.
+func (s *TimeWindowSample) Values() []int64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.copyValues()
+}
+
+// Variance returns the variance of the values in the window.
+func (s *TimeWindowSample) Variance() float64 { return s.Snapshot().Variance() }
+
+// slot returns the index of the current time slice
+func (s *TimeWindowSample) slot() int64 {
+	return s.now().UnixNano() / int64(s.width)
+}
+
+// live tells whether the slice of the bucket overlaps the window ending in the current slice
+func (b *timeBucket) live(current int64) bool {
+	return b.count > 0 && b.slot > current-timeWindowBuckets && b.slot <= current
+}
+
+// copyValues returns the values of the slices of the window, from the oldest to the newest
+func (s *TimeWindowSample) copyValues() []int64 {
+	current := s.slot()
+	values := []int64{}
+	for slot := current - timeWindowBuckets + 1; slot <= current; slot++ {
+		b := &s.buckets[int(slot%int64(len(s.buckets)))]
+		if b.slot == slot && b.live(current) {
+			values = append(values, b.values...)
+		}
+	}
+	return values
+}
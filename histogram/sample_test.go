@@ -0,0 +1,79 @@
+package histogram
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowSample(t *testing.T) {
+	s := NewSlidingWindowSample(3)
+	for i := int64(1); i <= 5; i++ {
+		s.Update(i)
+	}
+
+	if s.Count() != 5 || s.Size() != 3 {
+		t.Fatalf("unexpected count %d or size %d", s.Count(), s.Size())
+	}
+	if s.Min() != 3 || s.Max() != 5 || s.Sum() != 12 {
+		t.Fatalf("unexpected min %d, max %d or sum %d", s.Min(), s.Max(), s.Sum())
+	}
+
+	s.Clear()
+	if s.Count() != 0 || s.Size() != 0 {
+		t.Fatal("sample not cleared")
+	}
+}
+
+func TestTimeWindowSample(t *testing.T) {
+	now := time.Now()
+	s := NewTimeWindowSample(time.Minute).(*TimeWindowSample)
+	s.now = func() time.Time { return now }
+
+	s.Update(1)
+	now = now.Add(30 * time.Second)
+	s.Update(2)
+	now = now.Add(40 * time.Second)
+	s.Update(3)
+
+	if values := s.Values(); !reflect.DeepEqual(values, []int64{2, 3}) {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if s.Count() != 3 || s.Max() != 3 {
+		t.Fatalf("unexpected count %d or max %d", s.Count(), s.Max())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if s.Size() != 0 {
+		t.Fatalf("expected an empty window and got %d values", s.Size())
+	}
+}
+
+func TestTimeWindowSampleBounded(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := NewTimeWindowSample(time.Minute).(*TimeWindowSample)
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 100000; i++ {
+		s.Update(int64(i % 100))
+	}
+	if s.Count() != 100000 {
+		t.Fatalf("expected a count of 100000 and got %d", s.Count())
+	}
+	if size := s.Size(); size != timeWindowReservoirSize/timeWindowBuckets {
+		t.Fatalf("expected the sample of a single slice and got %d values", size)
+	}
+
+	// Each slice keeps its own sample, until it leaves the window
+	for i := 0; i < 5; i++ {
+		now = now.Add(10 * time.Second)
+		s.Update(1000)
+	}
+	if size := s.Size(); size != timeWindowReservoirSize/timeWindowBuckets+5 {
+		t.Fatalf("unexpected number of values %d", size)
+	}
+	now = now.Add(10 * time.Second)
+	if values := s.Values(); !reflect.DeepEqual(values, []int64{1000, 1000, 1000, 1000, 1000}) {
+		t.Fatalf("unexpected values %v", values)
+	}
+}
@@ -0,0 +1,468 @@
+package histogram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// DefaultRelativeAccuracy is the relative accuracy used for the sketches when none is given.
+const DefaultRelativeAccuracy = 0.01
+
+const sketchEncodingVersion byte = 1
+
+var (
+	ErrInvalidRelativeAccuracy error = errors.New("relative accuracy must be between 0 and 1")
+	ErrIncompatibleSketch      error = errors.New("sketches do not have the same relative accuracy")
+	ErrInvalidSketchEncoding   error = errors.New("invalid sketch encoding")
+)
+
+// Sketch is a metrics.Histogram backed by a relative-error sketch (DDSketch): every
+// percentile returned is within the relative accuracy of the real value, whatever the
+// distribution. Sketches with the same relative accuracy can be merged, and their
+// binary form can be pushed to be merged server side.
+type Sketch interface {
+	metrics.Histogram
+
+	// RelativeAccuracy returns the relative accuracy guaranteed for the percentiles.
+	RelativeAccuracy() float64
+
+	// Merge adds all the values of the given sketch into this one.
+	Merge(Sketch) error
+
+	// MarshalBinary encodes the sketch so it can be decoded with UnmarshalSketch.
+	MarshalBinary() ([]byte, error)
+}
+
+// NewSketch creates a Sketch with the given relative accuracy.
+func NewSketch(relativeAccuracy float64) (Sketch, error) {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		return nil, ErrInvalidRelativeAccuracy
+	}
+
+	return &StandardSketch{state: newSketchState(relativeAccuracy)}, nil
+}
+
+// UnmarshalSketch decodes a sketch encoded by Sketch.MarshalBinary.
+func UnmarshalSketch(data []byte) (Sketch, error) {
+	state, err := decodeSketchState(data)
+	if err != nil {
+		return nil, err
+	}
+	return &StandardSketch{state: state}, nil
+}
+
+// StandardSketch is the standard implementation of a Sketch.
+type StandardSketch struct {
+	state sketchState
+	m     sync.Mutex
+}
+
+// RelativeAccuracy returns the relative accuracy of the sketch.
+func (s *StandardSketch) RelativeAccuracy() float64 { return s.state.accuracy }
+
+// Merge adds all the values of the given sketch into this one.
+func (s *StandardSketch) Merge(other Sketch) error {
+	snapshot, ok := other.Snapshot().(*SketchSnapshot)
+	if !ok {
+		return ErrIncompatibleSketch
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.state.merge(&snapshot.state)
+}
+
+// MarshalBinary encodes the sketch.
+func (s *StandardSketch) MarshalBinary() ([]byte, error) {
+	return s.Snapshot().(*SketchSnapshot).MarshalBinary()
+}
+
+// Clear resets the sketch.
+func (s *StandardSketch) Clear() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.state = newSketchState(s.state.accuracy)
+}
+
+// Count returns the number of values recorded.
+func (s *StandardSketch) Count() int64 { return s.Snapshot().Count() }
+
+// Max returns the maximal value recorded.
+func (s *StandardSketch) Max() int64 { return s.Snapshot().Max() }
+
+// Mean returns the mean of the values recorded.
+func (s *StandardSketch) Mean() float64 { return s.Snapshot().Mean() }
+
+// Min returns the minimal value recorded.
+func (s *StandardSketch) Min() int64 { return s.Snapshot().Min() }
+
+// Percentile returns the given percentile, within the relative accuracy of the sketch.
+func (s *StandardSketch) Percentile(p float64) float64 { return s.Snapshot().Percentile(p) }
+
+// Percentiles returns the given percentiles, within the relative accuracy of the sketch.
+func (s *StandardSketch) Percentiles(ps []float64) []float64 { return s.Snapshot().Percentiles(ps) }
+
+// Sample returns a NilSample, the values are not kept by a Sketch.
+func (s *StandardSketch) Sample() metrics.Sample { return metrics.NilSample{} }
+
+// Snapshot returns a read-only copy of the sketch.
+func (s *StandardSketch) Snapshot() metrics.Histogram {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return &SketchSnapshot{state: s.state.copy()}
+}
+
+// StdDev returns the standard deviation of the values recorded.
+func (s *StandardSketch) StdDev() float64 { return s.Snapshot().StdDev() }
+
+// Sum returns the sum of the values recorded.
+func (s *StandardSketch) Sum() int64 { return s.Snapshot().Sum() }
+
+// Update records a new value.
+func (s *StandardSketch) Update(v int64) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.state.update(v)
+}
+
+// Variance returns the variance of the values recorded.
+func (s *StandardSketch) Variance() float64 { return s.Snapshot().Variance() }
+
+// SketchSnapshot is a read-only copy of a Sketch.
+type SketchSnapshot struct {
+	state sketchState
+}
+
+// RelativeAccuracy returns the relative accuracy of the sketch.
+func (s *SketchSnapshot) RelativeAccuracy() float64 { return s.state.accuracy }
+
+// Merge panics.
+func (*SketchSnapshot) Merge(Sketch) error {
+	panic("Merge called on a SketchSnapshot")
+}
+
+// MarshalBinary encodes the sketch.
+func (s *SketchSnapshot) MarshalBinary() ([]byte, error) {
+	return s.state.encode(), nil
+}
+
+// Clear panics.
+func (*SketchSnapshot) Clear() {
+	panic("Clear called on a SketchSnapshot")
+}
+
+// Count returns the number of values recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Count() int64 { return s.state.count }
+
+// Max returns the maximal value recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Max() int64 { return s.state.max }
+
+// Mean returns the mean of the values recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Mean() float64 {
+	if s.state.count == 0 {
+		return 0
+	}
+	return float64(s.state.sum) / float64(s.state.count)
+}
+
+// Min returns the minimal value recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Min() int64 { return s.state.min }
+
+// Percentile returns the given percentile at the time the snapshot was taken.
+func (s *SketchSnapshot) Percentile(p float64) float64 {
+	return s.state.percentile(p)
+}
+
+// Percentiles returns the given percentiles at the time the snapshot was taken.
+func (s *SketchSnapshot) Percentiles(ps []float64) []float64 {
+	values := make([]float64, len(ps))
+	for i, p := range ps {
+		values[i] = s.state.percentile(p)
+	}
+	return values
+}
+
+// Sample returns a NilSample, the values are not kept by a Sketch.
+func (s *SketchSnapshot) Sample() metrics.Sample { return metrics.NilSample{} }
+
+// Snapshot returns the snapshot.
+func (s *SketchSnapshot) Snapshot() metrics.Histogram { return s }
+
+// StdDev returns the standard deviation of the values recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) StdDev() float64 { return math.Sqrt(s.Variance()) }
+
+// Sum returns the sum of the values recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Sum() int64 { return s.state.sum }
+
+// Update panics.
+func (*SketchSnapshot) Update(int64) {
+	panic("Update called on a SketchSnapshot")
+}
+
+// Variance returns the variance of the values recorded at the time the snapshot was taken.
+func (s *SketchSnapshot) Variance() float64 {
+	if s.state.count == 0 {
+		return 0
+	}
+	mean := s.Mean()
+	return s.state.sumSquares/float64(s.state.count) - mean*mean
+}
+
+// sketchState holds the values shared by StandardSketch and SketchSnapshot.
+// A positive value v is counted in the bin ceil(log(v)/log(gamma)) of positives,
+// a negative one in the bin of -v of negatives.
+type sketchState struct {
+	accuracy  float64
+	logGamma  float64
+	positives map[int]int64
+	negatives map[int]int64
+	zeros     int64
+
+	count      int64
+	sum        int64
+	sumSquares float64
+	min, max   int64
+}
+
+func newSketchState(accuracy float64) sketchState {
+	return sketchState{
+		accuracy:  accuracy,
+		logGamma:  math.Log((1 + accuracy) / (1 - accuracy)),
+		positives: map[int]int64{},
+		negatives: map[int]int64{},
+	}
+}
+
+func (s *sketchState) copy() sketchState {
+	ret := *s
+	ret.positives = make(map[int]int64, len(s.positives))
+	for k, v := range s.positives {
+		ret.positives[k] = v
+	}
+	ret.negatives = make(map[int]int64, len(s.negatives))
+	for k, v := range s.negatives {
+		ret.negatives[k] = v
+	}
+	return ret
+}
+
+func (s *sketchState) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / s.logGamma))
+}
+
+// value returns the value representing a bin, at equal relative distance of its bounds
+func (s *sketchState) value(index int) float64 {
+	return 2 * math.Exp(float64(index)*s.logGamma) / (1 + math.Exp(s.logGamma))
+}
+
+func (s *sketchState) update(v int64) {
+	switch {
+	case v > 0:
+		s.positives[s.index(float64(v))]++
+	case v < 0:
+		s.negatives[s.index(-float64(v))]++
+	default:
+		s.zeros++
+	}
+
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.count++
+	s.sum += v
+	s.sumSquares += float64(v) * float64(v)
+}
+
+func (s *sketchState) merge(o *sketchState) error {
+	if s.accuracy != o.accuracy {
+		return ErrIncompatibleSketch
+	}
+	if o.count == 0 {
+		return nil
+	}
+
+	for k, v := range o.positives {
+		s.positives[k] += v
+	}
+	for k, v := range o.negatives {
+		s.negatives[k] += v
+	}
+	s.zeros += o.zeros
+
+	if s.count == 0 || o.min < s.min {
+		s.min = o.min
+	}
+	if s.count == 0 || o.max > s.max {
+		s.max = o.max
+	}
+	s.count += o.count
+	s.sum += o.sum
+	s.sumSquares += o.sumSquares
+	return nil
+}
+
+func (s *sketchState) percentile(p float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+
+	rank := p * float64(s.count-1)
+	var total int64
+
+	// The negatives are ranged from the largest absolute value to the smallest
+	for _, k := range sortedKeys(s.negatives, true) {
+		total += s.negatives[k]
+		if float64(total) > rank {
+			return s.clamp(-s.value(k))
+		}
+	}
+
+	total += s.zeros
+	if float64(total) > rank {
+		return 0
+	}
+
+	for _, k := range sortedKeys(s.positives, false) {
+		total += s.positives[k]
+		if float64(total) > rank {
+			return s.clamp(s.value(k))
+		}
+	}
+
+	return float64(s.max)
+}
+
+// clamp bounds the estimation of a value to the exact min and max recorded
+func (s *sketchState) clamp(v float64) float64 {
+	return math.Max(float64(s.min), math.Min(float64(s.max), v))
+}
+
+func (s *sketchState) encode() []byte {
+	var b bytes.Buffer
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	writeUvarint := func(v uint64) {
+		b.Write(buf[:binary.PutUvarint(buf, v)])
+	}
+	writeVarint := func(v int64) {
+		b.Write(buf[:binary.PutVarint(buf, v)])
+	}
+	writeBins := func(bins map[int]int64) {
+		writeUvarint(uint64(len(bins)))
+		for _, k := range sortedKeys(bins, false) {
+			writeVarint(int64(k))
+			writeVarint(bins[k])
+		}
+	}
+
+	b.WriteByte(sketchEncodingVersion)
+	writeUvarint(math.Float64bits(s.accuracy))
+	writeVarint(s.count)
+	writeVarint(s.sum)
+	writeUvarint(math.Float64bits(s.sumSquares))
+	writeVarint(s.min)
+	writeVarint(s.max)
+	writeVarint(s.zeros)
+	writeBins(s.positives)
+	writeBins(s.negatives)
+
+	return b.Bytes()
+}
+
+func decodeSketchState(data []byte) (sketchState, error) {
+	r := &sketchReader{r: bytes.NewReader(data)}
+
+	if version, err := r.r.ReadByte(); err != nil || version != sketchEncodingVersion {
+		return sketchState{}, ErrInvalidSketchEncoding
+	}
+
+	accuracy := r.float()
+	if r.err != nil || accuracy <= 0 || accuracy >= 1 {
+		return sketchState{}, ErrInvalidSketchEncoding
+	}
+
+	s := newSketchState(accuracy)
+	s.count = r.varint()
+	s.sum = r.varint()
+	s.sumSquares = r.float()
+	s.min = r.varint()
+	s.max = r.varint()
+	s.zeros = r.varint()
+	if r.err != nil || s.count < 0 || s.zeros < 0 || s.zeros > s.count {
+		return sketchState{}, ErrInvalidSketchEncoding
+	}
+
+	// The bins must hold the count, the total being bounded by it to not overflow
+	total := s.zeros
+	for _, bins := range []map[int]int64{s.positives, s.negatives} {
+		n := r.uvarint()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			k := r.varint()
+			v := r.varint()
+			if _, exists := bins[int(k)]; exists || v < 0 || v > s.count-total {
+				return sketchState{}, ErrInvalidSketchEncoding
+			}
+			bins[int(k)] = v
+			total += v
+		}
+	}
+
+	if r.err != nil || total != s.count {
+		return sketchState{}, ErrInvalidSketchEncoding
+	}
+	return s, nil
+}
+
+// sketchReader reads the values encoded by sketchState.encode, keeping the first error
+type sketchReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (sr *sketchReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	var v uint64
+	v, sr.err = binary.ReadUvarint(sr.r)
+	return v
+}
+
+func (sr *sketchReader) varint() int64 {
+	if sr.err != nil {
+		return 0
+	}
+	var v int64
+	v, sr.err = binary.ReadVarint(sr.r)
+	return v
+}
+
+func (sr *sketchReader) float() float64 {
+	return math.Float64frombits(sr.uvarint())
+}
+
+func sortedKeys(bins map[int]int64, reverse bool) []int {
+	keys := make([]int, 0, len(bins))
+	for k := range bins {
+		keys = append(keys, k)
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
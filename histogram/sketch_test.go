@@ -0,0 +1,127 @@
+package histogram
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestSketchAccuracy(t *testing.T) {
+	s, err := NewSketch(0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(-100); i <= 10000; i++ {
+		s.Update(i)
+	}
+
+	tests := []struct {
+		p, expected float64
+	}{
+		{p: 0, expected: -100},
+		{p: 0.5, expected: 4950},
+		{p: 0.99, expected: 9898.99},
+		{p: 1, expected: 10000},
+	}
+	for i, test := range tests {
+		value := s.Percentile(test.p)
+		if math.Abs(value-test.expected) > 0.01*math.Abs(test.expected)+1 {
+			t.Fatalf("test #%d failed : expected %f and got %f", i, test.expected, value)
+		}
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, _ := NewSketch(0.01)
+	b, _ := NewSketch(0.01)
+	for i := int64(1); i <= 100; i++ {
+		a.Update(i)
+		b.Update(i + 100)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count() != 200 || a.Min() != 1 || a.Max() != 200 || a.Sum() != 20100 {
+		t.Fatalf("unexpected count %d, min %d, max %d or sum %d", a.Count(), a.Min(), a.Max(), a.Sum())
+	}
+
+	c, _ := NewSketch(0.05)
+	if err := a.Merge(c); err != ErrIncompatibleSketch {
+		t.Fatalf("expected error `%s` and got `%s`", ErrIncompatibleSketch, err)
+	}
+}
+
+func TestSketchEncoding(t *testing.T) {
+	s, _ := NewSketch(0.02)
+	for _, v := range []int64{-42, 0, 1, 5, 1000, 123456} {
+		s.Update(v)
+	}
+
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := UnmarshalSketch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.RelativeAccuracy() != 0.02 || decoded.Count() != 6 || decoded.Percentile(0.5) != s.Percentile(0.5) {
+		t.Fatal("decoded sketch differs from the original one")
+	}
+
+	if _, err := UnmarshalSketch(data[:len(data)-1]); err != ErrInvalidSketchEncoding {
+		t.Fatalf("expected error `%s` and got `%s`", ErrInvalidSketchEncoding, err)
+	}
+}
+
+func TestUnmarshalSketchCorrupted(t *testing.T) {
+	// encode writes a sketch holding the count, the zeros and the positive bins given
+	encode := func(count, zeros int64, bins ...int64) []byte {
+		var b bytes.Buffer
+		buf := make([]byte, binary.MaxVarintLen64)
+		writeUvarint := func(v uint64) { b.Write(buf[:binary.PutUvarint(buf, v)]) }
+		writeVarint := func(v int64) { b.Write(buf[:binary.PutVarint(buf, v)]) }
+
+		b.WriteByte(sketchEncodingVersion)
+		writeUvarint(math.Float64bits(0.01))
+		writeVarint(count)
+		writeVarint(0)
+		writeUvarint(math.Float64bits(0))
+		writeVarint(0)
+		writeVarint(0)
+		writeVarint(zeros)
+		writeUvarint(uint64(len(bins)))
+		for k, v := range bins {
+			writeVarint(int64(k))
+			writeVarint(v)
+		}
+		writeUvarint(0)
+		return b.Bytes()
+	}
+
+	if _, err := UnmarshalSketch(encode(3, 1, 1, 1)); err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+
+	for i, data := range [][]byte{
+		// 0 negative count
+		encode(-1, 0),
+		// 1 negative zeros
+		encode(1, -1, 2),
+		// 2 negative bin
+		encode(1, 0, 2, -1),
+		// 3 bins below the count
+		encode(3, 1, 1),
+		// 4 bins above the count
+		encode(3, 1, 1, 1, 1),
+		// 5 bins overflowing
+		encode(math.MaxInt64, 0, math.MaxInt64, math.MaxInt64),
+	} {
+		if _, err := UnmarshalSketch(data); err != ErrInvalidSketchEncoding {
+			t.Fatalf("test #%d failed : expected error `%s` and got `%v`", i, ErrInvalidSketchEncoding, err)
+		}
+	}
+}
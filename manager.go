@@ -19,60 +19,71 @@ var (
 )
 
 type manager struct {
-	registers     map[string]*driver.Registry
+	registers     map[string]*registration
 	senders       []driver.Driver
-	flushCh       []chan struct{}
+	flushCh       chan struct{}
 	flushInterval time.Duration
-	flushMutex    sync.Mutex
 
-	wg sync.WaitGroup
-	l  sync.RWMutex
+	l sync.RWMutex
 
 	cancel context.CancelFunc
+	// done is closed once the manager is stopped, its drivers included
+	done chan struct{}
 }
 
-func (m *manager) run(ctx context.Context) {
-	ctx, cancel := context.WithCancel(ctx)
-	m.cancel = cancel
+// registration is a registry watched by the manager
+type registration struct {
+	*driver.Registry
 
-	// Create a goroutine to watch every senders
-	for _, s := range m.senders {
-		m.wg.Add(1)
-		m.flushMutex.Lock()
-		flushCh := make(chan struct{}, 1)
-		m.flushCh = append(m.flushCh, flushCh)
-		m.flushMutex.Unlock()
-		go func(s driver.Driver, flushCh chan struct{}) {
-			defer m.wg.Done()
-
-			// Create the ticker
-			m.l.RLock()
-			ticker := time.NewTicker(m.flushInterval)
-			m.l.RUnlock()
-
-			// Send metrics until the context is canceled
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					m.sendRegisters(s)
-				case <-flushCh:
-					m.sendRegisters(s)
-				}
-			}
-		}(s, flushCh)
+	// reset makes all the histograms and timers of the registry reset at each flush
+	reset bool
+}
+
+func newManager() *manager {
+	return &manager{
+		registers:     make(map[string]*registration),
+		flushCh:       make(chan struct{}, 1),
+		flushInterval: time.Minute,
 	}
+}
 
-	m.wg.Wait()
-	log.Debug("[metrics] stopped")
+// start runs the manager in the background, until it is stopped or the context is canceled
+func (m *manager) start(ctx context.Context) {
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.done = make(chan struct{})
+	go m.run(ctx)
 }
 
-func (m *manager) sendRegisters(d driver.Driver) {
+func (m *manager) run(ctx context.Context) {
+	defer close(m.done)
+
+	// Create the ticker, shared by all the senders so the metrics to reset are
+	// reset once per interval
+	m.l.RLock()
+	ticker := time.NewTicker(m.flushInterval)
+	m.l.RUnlock()
+	defer ticker.Stop()
+
+	// Send metrics until the context is canceled
+	for {
+		select {
+		case <-ctx.Done():
+			m.stopSenders()
+			log.Debug("[metrics] stopped")
+			return
+		case <-ticker.C:
+			m.sendRegisters()
+		case <-m.flushCh:
+			m.sendRegisters()
+		}
+	}
+}
+
+func (m *manager) sendRegisters() {
 	var toSend []*driver.Registry
 	m.l.RLock()
 	for _, register := range m.registers {
-		toSend = append(toSend, register)
+		toSend = append(toSend, register.snapshot())
 	}
 	m.l.RUnlock()
 
@@ -81,25 +92,73 @@ func (m *manager) sendRegisters(d driver.Driver) {
 		return
 	}
 
-	go func() {
-		err := d.Send(toSend)
-		if err != nil {
-			log.Error("[metrics] failed to send metrics")
+	for _, s := range m.senders {
+		go func(d driver.Driver) {
+			err := d.Send(toSend)
+			if err != nil {
+				log.Error("[metrics] failed to send metrics")
+			}
+		}(s)
+	}
+}
+
+// stopSenders releases the resources of the senders implementing driver.Stopper
+func (m *manager) stopSenders() {
+	for _, s := range m.senders {
+		if stopper, ok := s.(driver.Stopper); ok {
+			if err := stopper.Stop(); err != nil {
+				log.Errorf("[metrics] failed to stop a driver : %s", err)
+			}
 		}
-	}()
+	}
+}
+
+// snapshot returns the registry to send to the drivers, where the histograms and timers to reset
+// are replaced by a snapshot of their values during the last interval
+func (r *registration) snapshot() *driver.Registry {
+	registry := snapshotRegistry(r.Registry.Registry, r.reset)
+	if registry == r.Registry.Registry {
+		return r.Registry
+	}
+
+	return &driver.Registry{
+		Name:     r.Name,
+		Registry: registry,
+		Tags:     r.Tags,
+	}
 }
 
-func (m *manager) addRegistry(name string, r metrics.Registry, tags map[string]string) {
+func (m *manager) addRegistry(name string, r metrics.Registry, tags map[string]string, reset bool) {
 	m.l.Lock()
 	defer m.l.Unlock()
 
-	m.registers[registryID(name, tags)] = &driver.Registry{
-		Name:     name,
-		Registry: r,
-		Tags:     tags,
+	m.registers[registryID(name, tags)] = &registration{
+		Registry: &driver.Registry{
+			Name:     name,
+			Registry: r,
+			Tags:     tags,
+		},
+		reset: reset,
 	}
 }
 
+// addResettingRegistry adds a registry whose histograms and timers are all reset at each flush,
+// unless one of them cannot be reset
+func (m *manager) addResettingRegistry(name string, r metrics.Registry, tags map[string]string) error {
+	var err error
+	r.Each(func(metricName string, i interface{}) {
+		if err == nil && !isResettable(i) {
+			err = fmt.Errorf("metric %s : %w", metricName, ErrNotResettable)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	m.addRegistry(name, r, tags, true)
+	return nil
+}
+
 func (m *manager) setFlushInterval(d time.Duration) {
 	m.l.Lock()
 	defer m.l.Unlock()
@@ -115,24 +174,21 @@ func (m *manager) deleteRegistry(name string, tags map[string]string) error {
 		return ErrNotRegistered
 	}
 
-	delete(m.registers, name)
+	delete(m.registers, registryID(name, tags))
 	return nil
 }
 
 func (m *manager) flush() {
-	m.flushMutex.Lock()
-	defer m.flushMutex.Unlock()
-
-	for _, flushCh := range m.flushCh {
-		select {
-		case flushCh <- struct{}{}:
-		default:
-		}
+	select {
+	case m.flushCh <- struct{}{}:
+	default:
 	}
 }
 
+// stop stops the manager and waits for its drivers to be stopped
 func (m *manager) stop() {
 	m.cancel()
+	<-m.done
 }
 
 func registryID(name string, tags map[string]string) string {
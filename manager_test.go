@@ -1,6 +1,13 @@
 package metrics
 
-import "testing"
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
 
 func TestRegistryID(t *testing.T) {
 	tests := []struct {
@@ -53,3 +60,137 @@ func TestRegistryName(t *testing.T) {
 		}
 	}
 }
+
+func TestRegistrationSnapshot(t *testing.T) {
+	// 0 registry without metric to reset, sent as is
+	var noReset struct {
+		Latency metrics.Histogram
+	}
+
+	r, err := RegistryFromStruct(&noReset)
+	if err != nil {
+		t.Fatalf("[test #0] error is not nil : %s", err)
+	}
+
+	reg := &registration{Registry: &driver.Registry{Name: "test", Registry: r}}
+	if reg.snapshot() != reg.Registry {
+		t.Error("[test #0] registry without reset copied")
+	}
+
+	// 1 metrics reset through the tag
+	var withReset struct {
+		Latency  metrics.Histogram `metrics_reset:"flush"`
+		Duration metrics.Timer     `metrics_reset:"flush"`
+		Total    metrics.Histogram
+	}
+
+	r, err = RegistryFromStruct(&withReset)
+	if err != nil {
+		t.Fatalf("[test #1] error is not nil : %s", err)
+	}
+	withReset.Latency.Update(42)
+	withReset.Duration.Update(time.Second)
+	withReset.Total.Update(42)
+
+	reg = &registration{Registry: &driver.Registry{Name: "test", Registry: r}}
+	snapshot := reg.snapshot().Registry
+	if snapshot.Get("latency").(metrics.Histogram).Count() != 1 || snapshot.Get("duration").(metrics.Timer).Count() != 1 {
+		t.Error("[test #1] snapshot does not hold the values of the interval")
+	}
+	if withReset.Latency.Count() != 0 || withReset.Duration.Count() != 0 {
+		t.Error("[test #1] metrics not reset")
+	}
+	if withReset.Total.Count() != 1 || snapshot.Get("total") != withReset.Total {
+		t.Error("[test #1] metric without reset modified")
+	}
+
+	// 2 whole registry reset, the standard histograms and timers cannot be
+	r = metrics.NewRegistry()
+	h := NewResettableHistogram(func() metrics.Sample { return metrics.NewUniformSample(10) })
+	r.Register("histogram", h)
+	standard := metrics.NewRegisteredHistogram("standard", r, metrics.NewUniformSample(10))
+	timer := metrics.NewRegisteredTimer("timer", r)
+	h.Update(42)
+	standard.Update(42)
+	timer.Update(time.Second)
+
+	reg = &registration{Registry: &driver.Registry{Name: "test", Registry: r}, reset: true}
+	snapshot = reg.snapshot().Registry
+	if snapshot.Get("histogram").(metrics.Histogram).Count() != 1 || h.Count() != 0 {
+		t.Error("[test #2] histogram not reset")
+	}
+	if snapshot.Get("standard") != standard || standard.Count() != 1 {
+		t.Error("[test #2] standard histogram modified")
+	}
+	if snapshot.Get("timer") != timer || timer.Count() != 1 {
+		t.Error("[test #2] standard timer modified")
+	}
+}
+
+func TestResetConcurrentUpdates(t *testing.T) {
+	h := NewResettableHistogram(func() metrics.Sample { return metrics.NewUniformSample(10) })
+	timer := NewResettableTimer()
+
+	const updates = 10000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < updates; i++ {
+			h.Update(1)
+			timer.Update(time.Millisecond)
+		}
+	}()
+
+	// Every value is counted in exactly one interval
+	var hCount, timerCount int64
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		hCount += h.(resettable).snapshotAndReset().(metrics.Histogram).Count()
+		timerCount += timer.(resettable).snapshotAndReset().(metrics.Timer).Count()
+	}
+	if hCount != updates || timerCount != updates {
+		t.Fatalf("expected %d values and got %d for the histogram and %d for the timer", updates, hCount, timerCount)
+	}
+	if timer.Rate1() < 0 || timer.(*resettableTimer).meter.Count() != updates {
+		t.Fatal("rates of the timer reset")
+	}
+}
+
+func TestRegisterResetting(t *testing.T) {
+	m := newManager()
+	tags := map[string]string{"env": "test"}
+
+	r := metrics.NewRegistry()
+	metrics.NewRegisteredTimer("timer", r)
+	if err := m.addResettingRegistry("resetting", r, tags); !errors.Is(err, ErrNotResettable) {
+		t.Fatalf("expected error `%s` and got `%v`", ErrNotResettable, err)
+	}
+	if len(m.registers) != 0 {
+		t.Fatalf("expected the registry to be rejected and got %d registrations", len(m.registers))
+	}
+
+	r = metrics.NewRegistry()
+	r.Register("timer", NewResettableTimer())
+	r.Register("histogram", NewResettableHistogram(func() metrics.Sample { return metrics.NewUniformSample(10) }))
+	metrics.NewRegisteredCounter("counter", r)
+	if err := m.addResettingRegistry("resetting", r, tags); err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+	if reg, exists := m.registers[registryID("resetting", tags)]; !exists || !reg.reset {
+		t.Fatal("expected the registration to be reset at each flush")
+	}
+
+	if err := m.deleteRegistry("resetting", tags); err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+	if len(m.registers) != 0 {
+		t.Fatalf("expected the registration to be removed and got %d", len(m.registers))
+	}
+	if err := m.deleteRegistry("resetting", tags); err != ErrNotRegistered {
+		t.Fatalf("expected error `%s` and got `%v`", ErrNotRegistered, err)
+	}
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+)
+
+// metadataRegistry is the metrics.Registry created by RegistryFromStruct. It keeps
+// the metadata of its metrics to give them to the drivers.
+type metadataRegistry struct {
+	metrics.Registry
+	metadata sync.Map
+
+	// resets are the names of the metrics to reset at each flush
+	resets    sync.Map
+	withReset int32
+}
+
+func newMetadataRegistry() *metadataRegistry {
+	return &metadataRegistry{
+		Registry: metrics.NewRegistry(),
+	}
+}
+
+// Metadata is the implementation of driver.MetadataRegistry
+func (r *metadataRegistry) Metadata(name string) (driver.Metadata, bool) {
+	md, exists := r.metadata.Load(name)
+	if !exists {
+		return driver.Metadata{}, false
+	}
+	return md.(driver.Metadata), true
+}
+
+// Unregister deletes the metric and its metadata
+func (r *metadataRegistry) Unregister(name string) {
+	r.Registry.Unregister(name)
+	r.metadata.Delete(name)
+	r.resets.Delete(name)
+}
+
+// UnregisterAll deletes all the metrics and their metadata
+func (r *metadataRegistry) UnregisterAll() {
+	r.Registry.UnregisterAll()
+	r.metadata.Range(func(k, _ interface{}) bool {
+		r.metadata.Delete(k)
+		return true
+	})
+	r.resets.Range(func(k, _ interface{}) bool {
+		r.resets.Delete(k)
+		return true
+	})
+}
+
+func (r *metadataRegistry) hasResets() bool {
+	return atomic.LoadInt32(&r.withReset) == 1
+}
+
+func (r *metadataRegistry) resetOnFlush(name string) bool {
+	_, exists := r.resets.Load(name)
+	return exists
+}
+
+func (r *metadataRegistry) setResetOnFlush(name string) {
+	r.resets.Store(name, struct{}{})
+	atomic.StoreInt32(&r.withReset, 1)
+}
+
+func (r *metadataRegistry) registerWithMetadata(name string, i interface{}, md driver.Metadata) error {
+	if err := r.Registry.Register(name, i); err != nil {
+		return err
+	}
+	r.metadata.Store(name, md)
+	return nil
+}
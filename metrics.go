@@ -11,10 +11,7 @@ import (
 )
 
 var (
-	defaultManager *manager = &manager{
-		registers:     make(map[string]*driver.Registry),
-		flushInterval: time.Minute,
-	}
+	defaultManager *manager = newManager()
 )
 
 // Init starts metrics sending
@@ -40,14 +37,22 @@ func InitWithContext(ctx context.Context, appName string) error {
 			defaultManager.senders = append(defaultManager.senders, s)
 		}
 	}
-	go defaultManager.run(ctx)
+	defaultManager.start(ctx)
 	return nil
 }
 
 // Register adds a metrics.Registry to watch and send.
 // It will send all the metrics in through all the senders init until it has been unregistered
 func Register(name string, r metrics.Registry, tags map[string]string) {
-	defaultManager.addRegistry(name, r, tags)
+	defaultManager.addRegistry(name, r, tags, false)
+}
+
+// RegisterResetting works as Register, but all the histograms and timers of the registry are reset
+// at each flush : every value sent describes exactly the last flush interval.
+// The histograms and the timers must be created with NewResettableHistogram and NewResettableTimer,
+// the registry is not registered otherwise and an error wrapping ErrNotResettable is returned.
+func RegisterResetting(name string, r metrics.Registry, tags map[string]string) error {
+	return defaultManager.addResettingRegistry(name, r, tags)
 }
 
 // RegisterStruct takes a pointer to a struct containing metrics, creates a metrics.Registry and Registers it
@@ -61,6 +66,17 @@ func RegisterStruct(name string, s interface{}, tags map[string]string) (metrics
 	return r, nil
 }
 
+// RegisterStructStrict works as RegisterStruct but fails if a field of the struct cannot be registered
+func RegisterStructStrict(name string, s interface{}, tags map[string]string) (metrics.Registry, error) {
+	r, err := RegistryFromStructStrict(s)
+	if err != nil {
+		return nil, err
+	}
+
+	Register(name, r, tags)
+	return r, nil
+}
+
 // Unregister deletes the metrics.Registry to the list of the registry watched
 func Unregister(name string, tags map[string]string) error {
 	return defaultManager.deleteRegistry(name, tags)
@@ -76,7 +92,7 @@ func Flush() {
 	defaultManager.flush()
 }
 
-// Stop stops all the senders inited
+// Stop stops all the senders inited, and waits for the drivers to release their resources
 func Stop() {
 	defaultManager.stop()
 }
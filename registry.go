@@ -2,12 +2,17 @@ package metrics
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
 )
 
 var (
@@ -19,108 +24,455 @@ var (
 	ErrInvalidUniformSampleValue error = errors.New("invalid uniform value sample")
 	ErrInvalidExpSampleFormat    error = errors.New("invalid exp sample value format")
 	ErrInvalidExpSampleValue     error = errors.New("invalid exp sample value")
+	ErrInvalidSketchSampleValue  error = errors.New("invalid sketch sample value")
+	ErrInvalidSlidingSampleValue error = errors.New("invalid sliding sample value")
+	ErrInvalidWindowSampleValue  error = errors.New("invalid window sample value")
+	ErrFieldNotSettable          error = errors.New("field cannot be set, it must be exported")
+	ErrStructCycle               error = errors.New("struct already being explored, cycle detected")
+	ErrNilFunction               error = errors.New("function of gauge is nil")
+	ErrInvalidTagsFormat         error = errors.New("invalid metrics tags format")
+	ErrUnknownBucketsType        error = errors.New("unknown buckets type")
+	ErrInvalidBucketsFormat      error = errors.New("invalid buckets value format")
+	ErrInvalidBucketsValue       error = errors.New("invalid buckets value")
 )
 
 func sanitize(name string) string {
 	return strings.ToLower(name)
 }
 
+// FieldError is the error of a field rejected by RegistryFromStructStrict.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s : %s", e.Field, e.Err)
+}
+
+// StructError lists all the fields rejected by RegistryFromStructStrict.
+type StructError []*FieldError
+
+func (e StructError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		msgs = append(msgs, fieldErr.Error())
+	}
+	return fmt.Sprintf("invalid metrics struct : %s", strings.Join(msgs, ", "))
+}
+
 // RegistryFromStruct takes a data structure and creates a registry from its fields.
+// The named struct fields (pointer or value) are explored as well, their metrics being
+// registered with the name of the field as prefix, such as `db.queries`, unless they hold
+// no metric. The fields of the embedded structs are promoted, see structWalker for the
+// naming rules.
+// The families of metrics, such as *CounterFamily, are tagged with `metrics_label:""`
+// and filled through their With method.
+// The tags `metrics_tags:"k=v,k2=v2"`, `metrics_help:""` and `metrics_unit:""` are
+// given to the drivers with the metric. The histograms and timers tagged with
+// `metrics_reset:"flush"` are reset at each flush.
+// Fields of type func() int64 or func() float64, int64 and uint64 fields, and atomic
+// values with a Load method (such as atomic.Int64) are exposed as gauges read at
+// flush time. The numeric fields are read atomically.
+// The fields which cannot be registered are skipped.
 func RegistryFromStruct(s interface{}) (metrics.Registry, error) {
-	types := []interface{}{s}
-	ret := metrics.NewRegistry()
-	names := map[string]struct{}{}
+	return registryFromStruct(s, false)
+}
+
+// RegistryFromStructStrict works as RegistryFromStruct but fails if a field cannot be
+// registered, returning a StructError listing every field rejected and the reason.
+// The unexported fields are only rejected if they look like metrics.
+func RegistryFromStructStrict(s interface{}) (metrics.Registry, error) {
+	return registryFromStruct(s, true)
+}
+
+func registryFromStruct(s interface{}, strict bool) (metrics.Registry, error) {
+	if reflect.ValueOf(s).Kind() != reflect.Ptr {
+		return nil, ErrNotPointer
+	}
+
+	w := &structWalker{
+		registry:        newMetadataRegistry(),
+		names:           map[string]struct{}{},
+		visiting:        map[reflect.Type]struct{}{},
+		visitingStructs: map[structAddr]struct{}{},
+		strict:          strict,
+	}
+	if err := w.walk(reflect.ValueOf(s).Elem(), "", ""); err != nil {
+		return nil, err
+	}
+
+	if strict && len(w.errs) > 0 {
+		return nil, w.errs
+	}
 
-	for len(types) > 0 {
-		v := reflect.ValueOf(types[0])
-		types = types[:len(types)-1]
+	return w.registry, nil
+}
+
+// structWalker explores recursively the struct given to RegistryFromStruct and registers its metrics.
+//
+// The fields of the embedded structs, by value or by pointer and at any depth, are promoted :
+// they are registered with the prefix of the struct embedding them, as if they were declared
+// in it. A `metrics:""` tag on an embedded struct adds a prefix, as for a named struct field.
+// The names must be unique whatever the depth they are declared at, there is no shadowing.
+type structWalker struct {
+	registry *metadataRegistry
+	names    map[string]struct{}
+	strict   bool
+	errs     StructError
+
+	// visitingStructs holds the structs being explored, to detect the cycles of pointers
+	visitingStructs map[structAddr]struct{}
+	// visiting holds the types of the structs being explored, to not allocate the nil
+	// pointers to them endlessly
+	visiting map[reflect.Type]struct{}
+}
+
+// structAddr identifies a struct explored by the structWalker. The type is needed as an
+// embedded struct has the same address as the struct embedding it.
+type structAddr struct {
+	addr uintptr
+	t    reflect.Type
+}
+
+// reject logs why a field is skipped, and saves it to be returned in strict mode
+func (w *structWalker) reject(path string, err error) {
+	log.Debugf("[metrics] skipping field %s : %s", path, err)
+	w.errs = append(w.errs, &FieldError{Field: path, Err: err})
+}
+
+// walk registers the metrics of the struct v, prefixing their names with prefix.
+// path is the path of the fields of v from the struct given to RegistryFromStruct.
+func (w *structWalker) walk(v reflect.Value, prefix, path string) error {
+	t := v.Type()
+	w.visiting[t] = struct{}{}
+	defer delete(w.visiting, t)
+	if v.CanAddr() {
+		addr := structAddr{addr: v.UnsafeAddr(), t: t}
+		w.visitingStructs[addr] = struct{}{}
+		defer delete(w.visitingStructs, addr)
+	}
+
+	for idx := 0; idx < t.NumField(); idx++ {
+		// Getting information about the field
+		field := t.Field(idx)
+		fieldValue := v.Field(idx)
+		fieldPath := path + field.Name
 
-		if v.Kind() != reflect.Ptr {
-			return nil, ErrNotPointer
+		// Getting the name to register, in the tag `metrics:""` or the name of the field
+		tagName := field.Tag.Get("metrics")
+		name := field.Name
+		if tagName != "" {
+			name = tagName
 		}
+		name = prefix + sanitize(name)
 
-		for v.Kind() == reflect.Ptr {
-			v = v.Elem()
+		// Embedded structs are explored now, their fields being promoted
+		if field.Anonymous && isStruct(field.Type) {
+			log.Debugf("[metrics] found embedded struct %s, exploring", field.Name)
+			embeddedPrefix := prefix
+			if tagName != "" {
+				embeddedPrefix = name + "."
+			}
+			if err := w.walkStructField(field, fieldValue, embeddedPrefix, fieldPath); err != nil {
+				return err
+			}
+			continue
 		}
 
-		t := v.Type()
-		for idx := 0; idx < t.NumField(); idx++ {
-			// Getting information about the field
-			field := t.Field(idx)
-			fieldValue := v.Field(idx)
+		// Checking if the variable is settable, otherwise does not interest us
+		if !fieldValue.CanSet() {
+			if looksLikeMetric(field) {
+				w.reject(fieldPath, ErrFieldNotSettable)
+			} else {
+				log.Debugf("[metrics] cannot set field %s, skipping ", field.Name)
+			}
+			continue
+		}
 
-			if fieldValue.Kind() == reflect.Ptr && field.Anonymous {
-				log.Debugf("[metrics] found embedded pointer %s, exploring afterwards", field.Name)
-				types = append(types, fieldValue.Interface())
-				continue
+		// Nested structs are explored now, with the name of the field as prefix
+		if isStruct(field.Type) && !isGaugeField(field.Type) && !isFamily(field.Type) {
+			log.Debugf("[metrics] found nested struct %s, exploring", field.Name)
+			if err := w.walkStructField(field, fieldValue, name+".", fieldPath); err != nil {
+				return err
 			}
+			continue
+		}
 
-			// Checking if the variable is settable, otherwise does not interest us
-			if !fieldValue.CanSet() {
-				log.Debugf("[metrics] cannot set field %s, skipping ", field.Name)
+		if _, exists := w.names[name]; exists {
+			if !w.strict {
+				return ErrMetricsNameDuplicated
+			}
+			w.reject(fieldPath, ErrMetricsNameDuplicated)
+			continue
+		}
+		w.names[name] = struct{}{}
+
+		// Functions, numeric fields and atomic values are exposed as gauges read at flush time
+		if isGaugeField(field.Type) {
+			gauge, err := gaugeFromField(fieldValue)
+			if err != nil {
+				w.reject(fieldPath, err)
 				continue
 			}
+			if err := RegisterMetric(w.registry, name, gauge, field.Tag); err != nil {
+				w.reject(fieldPath, err)
+			}
+			continue
+		}
 
-			// Getting the name to register, in the tag `metrics:""` or the name of the field
-			name := field.Name
-			if tmp := field.Tag.Get("metrics"); tmp != "" {
-				name = tmp
+		// Families are registered empty, each metric being registered when its label value is used
+		if isFamily(field.Type) {
+			if fieldValue.Kind() != reflect.Ptr {
+				fieldValue = fieldValue.Addr()
+			} else if fieldValue.IsNil() {
+				fieldValue.Set(reflect.New(field.Type.Elem()))
 			}
-			name = sanitize(name)
-			if _, exists := names[name]; exists {
-				return nil, ErrMetricsNameDuplicated
+			if err := RegisterMetric(w.registry, name, fieldValue.Interface(), field.Tag); err != nil {
+				w.reject(fieldPath, err)
 			}
-			names[name] = struct{}{}
+			continue
+		}
 
-			// Instantiate the correct type or use the settled one, and push it in the struct
-			var newVar interface{}
-			if !fieldValue.IsNil() {
-				newVar = fieldValue.Interface()
-			} else {
-				var err error
-				newVar, err = metricFromField(fieldValue, field.Tag)
-				if err != nil {
-					log.Debugf("[metrics] unabled to instanciate metrics from field %s : %s", field.Name, err)
-					continue
-				}
-				fieldValue.Set(reflect.ValueOf(newVar).Convert(fieldValue.Type()))
+		// Only the interfaces of github.com/rcrowley/go-metrics can be instantiated
+		if fieldValue.Kind() != reflect.Interface {
+			w.reject(fieldPath, ErrMetricsTypeUnhandled)
+			continue
+		}
+
+		// Instantiate the correct type or use the settled one
+		newVar := fieldValue.Interface()
+		if fieldValue.IsNil() {
+			var err error
+			newVar, err = metricFromField(fieldValue, field.Tag)
+			if err != nil {
+				w.reject(fieldPath, err)
+				continue
 			}
+		}
 
-			// Add it in the registry
-			ret.Register(name, newVar)
+		// Add it in the registry, then push it in the struct
+		if err := RegisterMetric(w.registry, name, newVar, field.Tag); err != nil {
+			w.reject(fieldPath, err)
+			continue
+		}
+		if fieldValue.IsNil() {
+			fieldValue.Set(reflect.ValueOf(newVar).Convert(fieldValue.Type()))
 		}
 	}
 
-	return ret, nil
+	return nil
 }
 
-func metricFromField(v reflect.Value, tag reflect.StructTag) (interface{}, error) {
-	if !v.CanInterface() {
-		return nil, ErrNotInterface
+// walkStructField explores a struct field, by value or by pointer. The structs holding no
+// metric, such as an *http.Client, are left untouched unless the field has a `metrics:""` tag.
+// A pointer to a struct already being explored is a cycle. A nil pointer is allocated if the
+// field can be set, unless its type is already being explored : it is then left nil, as the
+// end of a chain such as a linked list.
+func (w *structWalker) walkStructField(field reflect.StructField, v reflect.Value, prefix, path string) error {
+	structType := field.Type
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	if field.Tag.Get("metrics") == "" && !containsMetrics(structType, map[reflect.Type]struct{}{}) {
+		log.Debugf("[metrics] struct %s holds no metric, skipping", path)
+		return nil
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			if _, exists := w.visiting[structType]; exists {
+				log.Debugf("[metrics] nil pointer %s to a struct being explored, skipping", path)
+				return nil
+			}
+			if !v.CanSet() {
+				w.reject(path, ErrFieldNotSettable)
+				return nil
+			}
+			v.Set(reflect.New(structType))
+		} else if _, exists := w.visitingStructs[structAddr{addr: v.Pointer(), t: structType}]; exists {
+			w.reject(path, ErrStructCycle)
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	return w.walk(v, prefix, path+".")
+}
+
+// containsMetrics returns whether the struct type, or one of the structs it nests, has fields
+// which are registered as metrics. seen holds the types already checked, to stop on the cycles.
+func containsMetrics(t reflect.Type, seen map[reflect.Type]struct{}) bool {
+	if _, exists := seen[t]; exists {
+		return false
+	}
+	seen[t] = struct{}{}
+
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if isMetricType(field.Type) || isGaugeField(field.Type) || isFamily(field.Type) {
+			return true
+		}
+		if !isStruct(field.Type) {
+			continue
+		}
+		if field.Tag.Get("metrics") != "" {
+			return true
+		}
+		nested := field.Type
+		if nested.Kind() == reflect.Ptr {
+			nested = nested.Elem()
+		}
+		if containsMetrics(nested, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMetricType returns whether the type is one of the metrics instantiated by metricFromField
+func isMetricType(t reflect.Type) bool {
+	switch t.String() {
+	case "metrics.Counter", "metrics.Gauge", "metrics.GaugeFloat64", "metrics.Meter",
+		"metrics.Timer", "metrics.Histogram", "histogram.Bucketed":
+		return true
+	}
+	return false
+}
+
+// looksLikeMetric returns whether a field which cannot be set was meant to be a metric
+func looksLikeMetric(field reflect.StructField) bool {
+	return field.Tag.Get("metrics") != "" || isFamily(field.Type) || isMetricType(field.Type)
+}
+
+// isStruct returns whether the type is a struct or a pointer to a struct
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
 	}
+	return t.Kind() == reflect.Struct
+}
 
-	switch v.Type().String() {
+var (
+	int64Type   = reflect.TypeOf(int64(0))
+	uint64Type  = reflect.TypeOf(uint64(0))
+	float64Type = reflect.TypeOf(float64(0))
+)
+
+// isGaugeField returns whether the type of the field can be exposed as a gauge
+// through gaugeFromField. Only the int64, uint64 and float64 types themselves are read,
+// the named types such as time.Duration or the enums are not values to expose.
+func isGaugeField(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int64, reflect.Uint64:
+		return t == int64Type || t == uint64Type
+	case reflect.Func:
+		return t.NumIn() == 0 && t.NumOut() == 1 && (t.Out(0) == int64Type || t.Out(0) == float64Type)
+	case reflect.Struct:
+		load, exists := reflect.PtrTo(t).MethodByName("Load")
+		if !exists || load.Type.NumIn() != 1 || load.Type.NumOut() != 1 {
+			return false
+		}
+		switch load.Type.Out(0) {
+		case int64Type, uint64Type, float64Type:
+			return true
+		}
+	}
+	return false
+}
 
-	case "metrics.Counter":
-		return metrics.NewCounter(), nil
+// gaugeFromField creates a functional gauge reading the field given
+func gaugeFromField(v reflect.Value) (interface{}, error) {
+	switch v.Kind() {
+	case reflect.Int64:
+		p := v.Addr().Convert(reflect.TypeOf((*int64)(nil))).Interface().(*int64)
+		return metrics.NewFunctionalGauge(func() int64 {
+			return atomic.LoadInt64(p)
+		}), nil
 
-	case "metrics.Gauge":
-		return metrics.NewGauge(), nil
+	case reflect.Uint64:
+		p := v.Addr().Convert(reflect.TypeOf((*uint64)(nil))).Interface().(*uint64)
+		return metrics.NewFunctionalGauge(func() int64 {
+			return int64(atomic.LoadUint64(p))
+		}), nil
 
-	case "metrics.GaugeFloat64":
-		return metrics.NewGaugeFloat64(), nil
+	case reflect.Func:
+		if v.IsNil() {
+			return nil, ErrNilFunction
+		}
+		// Copy the function to not be affected by a later change of the field
+		f := reflect.ValueOf(v.Interface())
+		if f.Type().Out(0).Kind() == reflect.Int64 {
+			return metrics.NewFunctionalGauge(func() int64 {
+				return f.Call(nil)[0].Int()
+			}), nil
+		}
+		return metrics.NewFunctionalGaugeFloat64(func() float64 {
+			return f.Call(nil)[0].Float()
+		}), nil
+	}
 
-	case "metrics.Meter":
-		return metrics.NewMeter(), nil
+	// Atomic values, read through their Load method
+	load := v.Addr().MethodByName("Load")
+	switch load.Type().Out(0).Kind() {
+	case reflect.Int64:
+		return metrics.NewFunctionalGauge(func() int64 {
+			return load.Call(nil)[0].Int()
+		}), nil
+	case reflect.Uint64:
+		return metrics.NewFunctionalGauge(func() int64 {
+			return int64(load.Call(nil)[0].Uint())
+		}), nil
+	}
+	return metrics.NewFunctionalGaugeFloat64(func() float64 {
+		return load.Call(nil)[0].Float()
+	}), nil
+}
 
-	case "metrics.Timer":
-		return metrics.NewTimer(), nil
+func metadataFromTag(tag reflect.StructTag) (driver.Metadata, error) {
+	md := driver.Metadata{
+		Help: tag.Get("metrics_help"),
+		Unit: tag.Get("metrics_unit"),
+	}
 
-	case "metrics.Histogram":
-		return newHistogram(tag.Get("metrics_sample"), tag.Get("metrics_sample_value"))
+	if rawTags := tag.Get("metrics_tags"); rawTags != "" {
+		md.Tags = map[string]string{}
+		for _, rawTag := range strings.Split(rawTags, ",") {
+			splitted := strings.SplitN(rawTag, "=", 2)
+			if len(splitted) != 2 || strings.TrimSpace(splitted[0]) == "" {
+				return driver.Metadata{}, ErrInvalidTagsFormat
+			}
+			md.Tags[strings.TrimSpace(splitted[0])] = strings.TrimSpace(splitted[1])
+		}
 	}
 
-	return nil, ErrMetricsTypeUnhandled
+	return md, nil
+}
+
+func metricFromField(v reflect.Value, tag reflect.StructTag) (interface{}, error) {
+	if !v.CanInterface() {
+		return nil, ErrNotInterface
+	}
+	return NewMetric(v.Type().String(), tag)
+}
+
+// newMaybeResettable creates a histogram with the function given, wrapped in a resettable
+// histogram if the tag asks to reset it at each flush
+func newMaybeResettable(newFunc func() (metrics.Histogram, error), tag reflect.StructTag) (interface{}, error) {
+	h, err := newFunc()
+	if err != nil {
+		return nil, err
+	}
+	if tag.Get("metrics_reset") != "flush" {
+		return h, nil
+	}
+
+	// The function is valid, its error can be ignored
+	return newResettableHistogram(func() metrics.Histogram {
+		h, _ := newFunc()
+		return h
+	}), nil
 }
 
 func newHistogram(sampleType, sampleValue string) (metrics.Histogram, error) {
@@ -154,9 +506,85 @@ func newHistogram(sampleType, sampleValue string) (metrics.Histogram, error) {
 			}
 		}
 		s = metrics.NewUniformSample(reservoirSize)
+	case "sliding":
+		size := 999
+		if sampleValue != "" {
+			var err error
+			size, err = strconv.Atoi(sampleValue)
+			if err != nil || size < 1 {
+				return nil, ErrInvalidSlidingSampleValue
+			}
+		}
+		s = histogram.NewSlidingWindowSample(size)
+	case "window":
+		window := time.Minute
+		if sampleValue != "" {
+			var err error
+			window, err = time.ParseDuration(sampleValue)
+			if err != nil || window <= 0 {
+				return nil, ErrInvalidWindowSampleValue
+			}
+		}
+		s = histogram.NewTimeWindowSample(window)
+	case "sketch":
+		relativeAccuracy := histogram.DefaultRelativeAccuracy
+		if sampleValue != "" {
+			var err error
+			relativeAccuracy, err = strconv.ParseFloat(sampleValue, 64)
+			if err != nil {
+				return nil, ErrInvalidSketchSampleValue
+			}
+		}
+
+		sketch, err := histogram.NewSketch(relativeAccuracy)
+		if err != nil {
+			return nil, ErrInvalidSketchSampleValue
+		}
+		return sketch, nil
 	default:
 		return nil, ErrUnknownSampleType
 	}
 
 	return metrics.NewHistogram(s), nil
 }
+
+func newBucketed(bucketsType, bucketsValue string) (histogram.Bucketed, error) {
+	var values []float64
+	if bucketsValue != "" {
+		for _, raw := range strings.Split(bucketsValue, ",") {
+			value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+			if err != nil {
+				return nil, ErrInvalidBucketsValue
+			}
+			values = append(values, value)
+		}
+	}
+
+	switch bucketsType {
+	case "linear", "exp":
+		if len(values) != 3 {
+			return nil, ErrInvalidBucketsFormat
+		}
+
+		count := int(values[2])
+		if float64(count) != values[2] || count < 1 {
+			return nil, ErrInvalidBucketsValue
+		}
+
+		if bucketsType == "linear" {
+			if values[1] <= 0 {
+				return nil, ErrInvalidBucketsValue
+			}
+			return histogram.NewBucketed(histogram.LinearBuckets(values[0], values[1], count)), nil
+		}
+
+		if values[0] <= 0 || values[1] <= 1 {
+			return nil, ErrInvalidBucketsValue
+		}
+		return histogram.NewBucketed(histogram.ExponentialBuckets(values[0], values[1], count)), nil
+	case "", "explicit":
+		return histogram.NewBucketed(values), nil
+	}
+
+	return nil, ErrUnknownBucketsType
+}
@@ -1,9 +1,16 @@
 package metrics
 
 import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/rcrowley/go-metrics"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
 )
 
 func TestRegistryFromStruct(t *testing.T) {
@@ -41,7 +48,8 @@ func TestRegistryFromStruct(t *testing.T) {
 	var correct struct {
 		Toto metrics.Counter
 		Titi metrics.Meter
-		Tutu metrics.Histogram `metrics_sample_value:"42"`
+		Tutu metrics.Histogram  `metrics_sample_value:"42"`
+		Tata histogram.Bucketed `metrics_buckets:"linear" metrics_buckets_value:"0,10,5"`
 	}
 
 	r, err := RegistryFromStruct(&correct)
@@ -60,6 +68,12 @@ func TestRegistryFromStruct(t *testing.T) {
 	if !ok {
 		t.Fatal("Test #3 failed : field titi is not type of Meter")
 	}
+
+	b := r.Get("tata")
+	_, ok = b.(histogram.Bucketed)
+	if !ok {
+		t.Fatal("Test #3 failed : field tata is not type of Bucketed")
+	}
 }
 
 func TestNewHistogram(t *testing.T) {
@@ -122,6 +136,69 @@ func TestNewHistogram(t *testing.T) {
 			sampleValue: "42",
 			errExpected: nil,
 		},
+
+		//8 not float
+		{
+			sampleType:  "sketch",
+			sampleValue: "abc",
+			errExpected: ErrInvalidSketchSampleValue,
+		},
+
+		//9 accuracy out of bounds
+		{
+			sampleType:  "sketch",
+			sampleValue: "1.5",
+			errExpected: ErrInvalidSketchSampleValue,
+		},
+
+		//10 default accuracy
+		{
+			sampleType:  "sketch",
+			sampleValue: "",
+			errExpected: nil,
+		},
+
+		//11
+		{
+			sampleType:  "sketch",
+			sampleValue: "0.005",
+			errExpected: nil,
+		},
+
+		//12 not int
+		{
+			sampleType:  "sliding",
+			sampleValue: "abc",
+			errExpected: ErrInvalidSlidingSampleValue,
+		},
+
+		//13 empty window
+		{
+			sampleType:  "sliding",
+			sampleValue: "0",
+			errExpected: ErrInvalidSlidingSampleValue,
+		},
+
+		//14
+		{
+			sampleType:  "sliding",
+			sampleValue: "100",
+			errExpected: nil,
+		},
+
+		//15 not a duration
+		{
+			sampleType:  "window",
+			sampleValue: "42",
+			errExpected: ErrInvalidWindowSampleValue,
+		},
+
+		//16
+		{
+			sampleType:  "window",
+			sampleValue: "1m",
+			errExpected: nil,
+		},
 	}
 
 	for i, test := range tests {
@@ -131,3 +208,587 @@ func TestNewHistogram(t *testing.T) {
 		}
 	}
 }
+
+func TestNewBucketed(t *testing.T) {
+	tests := []struct {
+		bucketsType, bucketsValue string
+		errExpected               error
+		bucketsExpected           int
+	}{
+		//0 invalid type
+		{
+			bucketsType:  "uknz",
+			bucketsValue: "",
+			errExpected:  ErrUnknownBucketsType,
+		},
+
+		//1 not 3 values
+		{
+			bucketsType:  "linear",
+			bucketsValue: "0,10",
+			errExpected:  ErrInvalidBucketsFormat,
+		},
+
+		//2 not float
+		{
+			bucketsType:  "linear",
+			bucketsValue: "0,abc,5",
+			errExpected:  ErrInvalidBucketsValue,
+		},
+
+		//3 count not int
+		{
+			bucketsType:  "linear",
+			bucketsValue: "0,10,2.5",
+			errExpected:  ErrInvalidBucketsValue,
+		},
+
+		//4
+		{
+			bucketsType:     "linear",
+			bucketsValue:    "0,10,5",
+			bucketsExpected: 5,
+		},
+
+		//5 factor lower than 1
+		{
+			bucketsType:  "exp",
+			bucketsValue: "1,0.5,5",
+			errExpected:  ErrInvalidBucketsValue,
+		},
+
+		//6
+		{
+			bucketsType:     "exp",
+			bucketsValue:    "1,2,10",
+			bucketsExpected: 10,
+		},
+
+		//7 explicit with duplicates
+		{
+			bucketsType:     "",
+			bucketsValue:    "1,5,5,10",
+			bucketsExpected: 3,
+		},
+
+		//8 default buckets
+		{
+			bucketsType:     "",
+			bucketsValue:    "",
+			bucketsExpected: len(histogram.DefaultBuckets),
+		},
+	}
+
+	for i, test := range tests {
+		h, err := newBucketed(test.bucketsType, test.bucketsValue)
+		if err != test.errExpected {
+			t.Fatalf("test #%d failed : expected `%s` and got `%s`", i, test.errExpected, err)
+		}
+		if err == nil && len(h.Buckets()) != test.bucketsExpected {
+			t.Fatalf("test #%d failed : expected %d buckets and got %d", i, test.bucketsExpected, len(h.Buckets()))
+		}
+	}
+}
+
+func TestRegistryFromStructNested(t *testing.T) {
+	type dbMetrics struct {
+		Queries metrics.Counter
+		Errors  metrics.Counter
+	}
+
+	// 0 pointer and value nested structs
+	var nested struct {
+		DB    *dbMetrics `metrics:"db"`
+		Cache struct {
+			Hits metrics.Meter
+		}
+	}
+
+	r, err := RegistryFromStruct(&nested)
+	if err != nil {
+		t.Fatalf("Test #0 failed : error is not nil : %s", err)
+	}
+
+	for _, name := range []string{"db.queries", "db.errors", "cache.hits"} {
+		if r.Get(name) == nil {
+			t.Fatalf("Test #0 failed : metric %s not registered", name)
+		}
+	}
+	if nested.DB == nil || r.Get("db.queries") != nested.DB.Queries {
+		t.Fatal("Test #0 failed : nested pointer not set")
+	}
+
+	// 1 collision between a nested metric and a tag
+	var collision struct {
+		DB       dbMetrics       `metrics:"db"`
+		DBErrors metrics.Counter `metrics:"db.errors"`
+	}
+
+	_, err = RegistryFromStruct(&collision)
+	if err != ErrMetricsNameDuplicated {
+		t.Fatalf("Test #1 failed : expected error `%s` and got `%s`", ErrMetricsNameDuplicated, err)
+	}
+}
+
+func TestRegistryFromStructLabeled(t *testing.T) {
+	// 0 missing label, the family is rejected
+	var missingLabel struct {
+		ByStatus *CounterFamily `metrics:"requests"`
+	}
+
+	_, err := RegistryFromStructStrict(&missingLabel)
+	structErr, ok := err.(StructError)
+	if !ok || len(structErr) != 1 || structErr[0].Err != ErrMissingLabel {
+		t.Fatalf("Test #0 failed : expected error `%s` and got `%v`", ErrMissingLabel, err)
+	}
+
+	// 1 family not registered
+	var unregistered CounterFamily
+	if _, err := unregistered.With("200"); err != ErrFamilyNotRegistered {
+		t.Fatalf("Test #1 failed : expected error `%s` and got `%v`", ErrFamilyNotRegistered, err)
+	}
+
+	// 2 correct, the family being allocated
+	var labeled struct {
+		ByStatus *CounterFamily  `metrics:"requests" metrics_label:"status"`
+		Latency  BucketedFamily  `metrics_label:"route" metrics_buckets:"linear" metrics_buckets_value:"0,10,5"`
+		Invalid  HistogramFamily `metrics_label:"route" metrics_sample:"unknown"`
+	}
+
+	r, err := RegistryFromStruct(&labeled)
+	if err != nil {
+		t.Fatalf("Test #2 failed : error is not nil : %s", err)
+	}
+
+	c, err := labeled.ByStatus.With("200")
+	if err != nil {
+		t.Fatalf("Test #2 failed : error is not nil : %s", err)
+	}
+	c.Inc(1)
+	if again, _ := labeled.ByStatus.With("200"); again != c {
+		t.Fatal("Test #2 failed : labeled metric not reused")
+	}
+	labeled.ByStatus.With("500")
+	if h, err := labeled.Latency.With("/"); err != nil || len(h.Buckets()) != 5 {
+		t.Fatalf("Test #2 failed : unexpected bucketed histogram, error %v", err)
+	}
+	if _, err := labeled.Invalid.With("/"); err != ErrFamilyNotRegistered {
+		t.Fatalf("Test #2 failed : expected error `%s` and got `%v`", ErrFamilyNotRegistered, err)
+	}
+
+	found := map[string]int64{}
+	driver.Each(r, map[string]string{"env": "prod"}, func(name string, i interface{}, md driver.Metadata) {
+		if name == "latency" {
+			return
+		}
+		if name != "requests" || md.Tags["env"] != "prod" {
+			t.Fatalf("Test #2 failed : unexpected metric %s with tags %v", name, md.Tags)
+		}
+		found[md.Tags["status"]] = i.(metrics.Counter).Count()
+	})
+	if len(found) != 2 || found["200"] != 1 || found["500"] != 0 {
+		t.Fatalf("Test #2 failed : unexpected metrics %v", found)
+	}
+}
+
+func TestFamilyConcurrentWith(t *testing.T) {
+	var s struct {
+		ByStatus *CounterFamily `metrics:"requests" metrics_label:"status"`
+	}
+	r, err := RegistryFromStruct(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c, err := s.ByStatus.With(strconv.Itoa(j % 10))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				c.Inc(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var total int64
+	r.Each(func(_ string, i interface{}) {
+		total += i.(metrics.Counter).Count()
+	})
+	if total != 800 {
+		t.Fatalf("expected 800 increments and got %d", total)
+	}
+}
+
+func TestRegistryFromStructMetadata(t *testing.T) {
+	var withMetadata struct {
+		Sent     metrics.Counter `metrics:"sent" metrics_tags:"direction=out, proto=tcp" metrics_help:"Bytes sent" metrics_unit:"bytes"`
+		Invalid  metrics.Counter `metrics_tags:"direction"`
+		ByStatus *CounterFamily  `metrics:"requests" metrics_label:"status" metrics_tags:"proto=http" metrics_help:"Requests by status"`
+	}
+
+	r, err := RegistryFromStruct(&withMetadata)
+	if err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+	if r.Get("invalid") != nil {
+		t.Fatal("metric with invalid tags registered")
+	}
+	withMetadata.ByStatus.With("200")
+
+	tests := []struct {
+		name string
+		md   driver.Metadata
+	}{
+		{
+			name: "sent",
+			md: driver.Metadata{
+				Name: "sent",
+				Tags: map[string]string{"env": "prod", "direction": "out", "proto": "tcp"},
+				Help: "Bytes sent",
+				Unit: "bytes",
+			},
+		},
+		{
+			name: "requests",
+			md: driver.Metadata{
+				Name: "requests",
+				Tags: map[string]string{"env": "prod", "status": "200", "proto": "http"},
+				Help: "Requests by status",
+			},
+		},
+	}
+
+	found := map[string]driver.Metadata{}
+	driver.Each(r, map[string]string{"env": "prod"}, func(name string, i interface{}, md driver.Metadata) {
+		found[name] = md
+	})
+	for n, test := range tests {
+		if !reflect.DeepEqual(found[test.name], test.md) {
+			t.Errorf("[test #%d] expected metadata %+v, got %+v", n, test.md, found[test.name])
+		}
+	}
+}
+
+type atomicValue struct {
+	v int64
+}
+
+func (a *atomicValue) Load() int64 {
+	return a.v
+}
+
+func TestRegistryFromStructGauges(t *testing.T) {
+	var gauges struct {
+		Connections int64
+		Sent        uint64
+		Queue       atomicValue
+		Goroutines  func() int64
+		Ratio       func() float64
+		Missing     func() int64
+		// The named integer types are not values to expose
+		Timeout time.Duration
+		Elapsed func() time.Duration
+		State   gaugeState
+	}
+	gauges.Goroutines = func() int64 { return 42 }
+	gauges.Elapsed = func() time.Duration { return time.Second }
+	gauges.Ratio = func() float64 { return 0.5 }
+
+	r, err := RegistryFromStruct(&gauges)
+	if err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+	gauges.Connections = 12
+	gauges.Sent = 24
+	gauges.Queue.v = 36
+
+	tests := []struct {
+		name     string
+		expected int64
+	}{
+		{name: "connections", expected: 12},
+		{name: "sent", expected: 24},
+		{name: "queue", expected: 36},
+		{name: "goroutines", expected: 42},
+	}
+	for n, test := range tests {
+		g, ok := r.Get(test.name).(metrics.Gauge)
+		if !ok {
+			t.Fatalf("[test #%d] field %s is not type of Gauge", n, test.name)
+		}
+		if g.Value() != test.expected {
+			t.Errorf("[test #%d] expected %d, got %d", n, test.expected, g.Value())
+		}
+	}
+
+	g, ok := r.Get("ratio").(metrics.GaugeFloat64)
+	if !ok || g.Value() != 0.5 {
+		t.Fatal("field ratio is not a GaugeFloat64 reading the function")
+	}
+	if r.Get("missing") != nil {
+		t.Fatal("nil function registered")
+	}
+	for _, name := range []string{"timeout", "elapsed", "state"} {
+		if r.Get(name) != nil {
+			t.Fatalf("field %s of a named integer type registered as %T", name, r.Get(name))
+		}
+	}
+}
+
+// gaugeState is an enum, not a gauge
+type gaugeState int64
+
+func TestRegistryFromStructStrict(t *testing.T) {
+	// 0 correct
+	var correct struct {
+		Toto metrics.Counter
+		Tutu metrics.Histogram `metrics_sample_value:"42"`
+		m    int
+	}
+
+	_, err := RegistryFromStructStrict(&correct)
+	if err != nil {
+		t.Fatalf("Test #0 failed : error is not nil : %s", err)
+	}
+
+	// 1 every invalid field is listed
+	var invalid struct {
+		Toto   metrics.Counter   `metrics:"my-tag"`
+		Titi   metrics.Counter   `metrics:"my-tag"`
+		Sample metrics.Histogram `metrics_sample:"unknown"`
+		Name   string
+		Nested struct {
+			Tags metrics.Counter `metrics_tags:"a"`
+		}
+		hidden metrics.Counter
+	}
+
+	_, err = RegistryFromStructStrict(&invalid)
+	structErr, ok := err.(StructError)
+	if !ok {
+		t.Fatalf("Test #1 failed : expected a StructError and got `%s`", err)
+	}
+
+	expected := []*FieldError{
+		{Field: "Titi", Err: ErrMetricsNameDuplicated},
+		{Field: "Sample", Err: ErrUnknownSampleType},
+		{Field: "Name", Err: ErrMetricsTypeUnhandled},
+		{Field: "Nested.Tags", Err: ErrInvalidTagsFormat},
+		{Field: "hidden", Err: ErrFieldNotSettable},
+	}
+	if !reflect.DeepEqual([]*FieldError(structErr), expected) {
+		t.Fatalf("Test #1 failed : unexpected error `%s`", err)
+	}
+
+	// 2 the default mode skips the invalid fields
+	var skipped struct {
+		Sample metrics.Histogram `metrics_sample:"unknown"`
+		Name   string
+	}
+
+	_, err = RegistryFromStruct(&skipped)
+	if err != nil {
+		t.Fatalf("Test #2 failed : error is not nil : %s", err)
+	}
+}
+
+type embeddedBase struct {
+	Requests metrics.Counter
+}
+
+type embeddedMiddle struct {
+	embeddedBase
+	Errors metrics.Counter
+}
+
+type EmbeddedPointer struct {
+	Latency metrics.Timer
+}
+
+type CycleA struct {
+	*CycleB
+	A metrics.Counter
+}
+
+type CycleB struct {
+	*CycleA
+	B metrics.Counter
+}
+
+type linkedNode struct {
+	Value metrics.Gauge
+	Next  *linkedNode
+}
+
+func TestRegistryFromStructEmbedded(t *testing.T) {
+	// 0 value and pointer embeds at any depth, promoted or prefixed
+	var embedded struct {
+		embeddedMiddle
+		*EmbeddedPointer
+		Prefixed embeddedBase `metrics:"prefixed"`
+		Other    metrics.Meter
+	}
+
+	r, err := RegistryFromStructStrict(&embedded)
+	if err != nil {
+		t.Fatalf("Test #0 failed : error is not nil : %s", err)
+	}
+
+	for _, name := range []string{"requests", "errors", "latency", "prefixed.requests", "other"} {
+		if r.Get(name) == nil {
+			t.Fatalf("Test #0 failed : metric %s not registered", name)
+		}
+	}
+	if embedded.EmbeddedPointer == nil || embedded.Requests == nil || embedded.Latency == nil {
+		t.Fatal("Test #0 failed : embedded fields not set")
+	}
+
+	// 1 promoted field colliding with a field of the struct
+	var collision struct {
+		embeddedBase
+		Requests metrics.Counter
+	}
+
+	_, err = RegistryFromStruct(&collision)
+	if err != ErrMetricsNameDuplicated {
+		t.Fatalf("Test #1 failed : expected error `%s` and got `%s`", ErrMetricsNameDuplicated, err)
+	}
+
+	// 2 cycle through embedded pointers
+	cycle := &CycleA{CycleB: &CycleB{}}
+	cycle.CycleB.CycleA = cycle
+
+	_, err = RegistryFromStructStrict(cycle)
+	expected := StructError{{Field: "CycleB.CycleA", Err: ErrStructCycle}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("Test #2 failed : expected error `%s` and got `%s`", expected, err)
+	}
+
+	// 3 nil pointer to a struct being explored, left nil
+	var node linkedNode
+
+	r, err = RegistryFromStructStrict(&node)
+	if err != nil {
+		t.Fatalf("Test #3 failed : error is not nil : %s", err)
+	}
+	if r.Get("value") == nil || node.Next != nil {
+		t.Fatal("Test #3 failed : recursion not stopped")
+	}
+
+	// 4 chain of structs of the same type, which is not a cycle
+	chain := &linkedNode{Next: &linkedNode{}}
+
+	r, err = RegistryFromStructStrict(chain)
+	if err != nil {
+		t.Fatalf("Test #4 failed : error is not nil : %s", err)
+	}
+	if r.Get("value") == nil || r.Get("next.value") == nil || chain.Next.Next != nil {
+		t.Fatal("Test #4 failed : chain not registered")
+	}
+
+	// 5 cycle through named fields, skipped in the default mode
+	loop := &linkedNode{}
+	loop.Next = loop
+
+	r, err = RegistryFromStruct(loop)
+	if err != nil {
+		t.Fatalf("Test #5 failed : error is not nil : %s", err)
+	}
+	if r.Get("value") == nil || r.Get("next.value") != nil {
+		t.Fatal("Test #5 failed : cycle not stopped")
+	}
+}
+
+func TestRegistryFromStructReset(t *testing.T) {
+	var withReset struct {
+		Sizes    histogram.Bucketed `metrics_reset:"flush" metrics_buckets_value:"10,100"`
+		Standard metrics.Timer      `metrics_reset:"flush"`
+		Unknown  metrics.Histogram  `metrics_reset:"never"`
+	}
+	withReset.Standard = metrics.NewTimer()
+
+	_, err := RegistryFromStructStrict(&withReset)
+	expected := StructError{
+		{Field: "Standard", Err: ErrNotResettable},
+		{Field: "Unknown", Err: ErrUnknownResetMode},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Fatalf("expected error `%s` and got `%v`", expected, err)
+	}
+
+	if _, ok := withReset.Sizes.(resettable); !ok || len(withReset.Sizes.Buckets()) != 2 {
+		t.Fatal("bucketed histogram to reset not resettable")
+	}
+}
+
+func TestRegistryFromStructWithoutMetrics(t *testing.T) {
+	var s struct {
+		Timeout time.Duration
+		Client  *http.Client
+		Reqs    metrics.Counter
+	}
+
+	r, err := RegistryFromStruct(&s)
+	if err != nil {
+		t.Fatalf("error is not nil : %s", err)
+	}
+	if s.Client != nil {
+		t.Fatal("the nil *http.Client holding no metric was allocated")
+	}
+	names := []string{}
+	r.Each(func(name string, _ interface{}) {
+		names = append(names, name)
+	})
+	if !reflect.DeepEqual(names, []string{"reqs"}) {
+		t.Fatalf("expected only reqs to be registered and got %v", names)
+	}
+}
+
+func TestRegisterMetric(t *testing.T) {
+	// 0 metric instantiated and registered with its tags
+	tag := reflect.StructTag(`metrics_reset:"flush" metrics_help:"latency of the requests"`)
+	i, err := NewMetric("metrics.Timer", tag)
+	if err != nil {
+		t.Fatalf("Test #0 failed : error is not nil : %s", err)
+	}
+	if !isResettable(i) {
+		t.Fatal("Test #0 failed : timer is not resettable")
+	}
+	r := NewTaggedRegistry()
+	if err := RegisterMetric(r, "latency", i, tag); err != nil {
+		t.Fatalf("Test #0 failed : error is not nil : %s", err)
+	}
+	if md, _ := r.(driver.MetadataRegistry).Metadata("latency"); md.Help != "latency of the requests" {
+		t.Fatalf("Test #0 failed : unexpected help %q", md.Help)
+	}
+	if !r.(*metadataRegistry).resetOnFlush("latency") {
+		t.Fatal("Test #0 failed : timer not reset on flush")
+	}
+
+	// 1 type which is not a metric
+	if _, err := NewMetric("string", ""); err != ErrMetricsTypeUnhandled {
+		t.Fatalf("Test #1 failed : expected error `%s` and got `%s`", ErrMetricsTypeUnhandled, err)
+	}
+
+	// 2 invalid sample, rejected as by RegistryFromStruct
+	if _, err := NewMetric("metrics.Histogram", `metrics_sample:"unknown"`); err != ErrUnknownSampleType {
+		t.Fatalf("Test #2 failed : expected error `%s` and got `%s`", ErrUnknownSampleType, err)
+	}
+
+	// 3 family without label
+	if err := RegisterMetric(NewTaggedRegistry(), "status", &CounterFamily{}, ""); err != ErrMissingLabel {
+		t.Fatalf("Test #3 failed : expected error `%s` and got `%s`", ErrMissingLabel, err)
+	}
+
+	// 4 registry not created by the package
+	if err := RegisterMetric(metrics.NewRegistry(), "toto", metrics.NewCounter(), ""); err != ErrNotTaggedRegistry {
+		t.Fatalf("Test #4 failed : expected error `%s` and got `%s`", ErrNotTaggedRegistry, err)
+	}
+}
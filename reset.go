@@ -0,0 +1,289 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/ybriffa/metrics/driver"
+	"github.com/ybriffa/metrics/histogram"
+)
+
+var (
+	ErrUnknownResetMode error = errors.New("unknown reset mode")
+	ErrNotResettable    error = errors.New("metric cannot be reset, it must be created with NewResettableHistogram or NewResettableTimer")
+)
+
+// resettable is implemented by the metrics which can be reset at each flush without losing
+// any value : the values recorded are either part of the snapshot returned, or of the next one.
+type resettable interface {
+	snapshotAndReset() interface{}
+}
+
+// resettingRegistry is implemented by the registries resetting some of their metrics at each flush
+type resettingRegistry interface {
+	hasResets() bool
+	resetOnFlush(name string) bool
+}
+
+// resettableHistogram is a metrics.Histogram replaced by a new one at each reset. The updates
+// share the read lock, the reset takes the write lock to swap the histogram.
+type resettableHistogram struct {
+	m            sync.RWMutex
+	h            metrics.Histogram
+	newHistogram func() metrics.Histogram
+}
+
+// NewResettableHistogram creates a metrics.Histogram which can be reset at each flush, when
+// registered with RegisterResetting or with the tag `metrics_reset:"flush"`. A new sample is
+// created at each reset.
+func NewResettableHistogram(newSample func() metrics.Sample) metrics.Histogram {
+	return newResettableHistogram(func() metrics.Histogram {
+		return metrics.NewHistogram(newSample())
+	})
+}
+
+// newResettableHistogram creates a resettable histogram. The histogram.Bucketed stay bucketed.
+func newResettableHistogram(newHistogram func() metrics.Histogram) metrics.Histogram {
+	h := &resettableHistogram{h: newHistogram(), newHistogram: newHistogram}
+	if _, ok := h.h.(histogram.Bucketed); ok {
+		return &resettableBucketed{h}
+	}
+	return h
+}
+
+func (h *resettableHistogram) snapshotAndReset() interface{} {
+	h.m.Lock()
+	old := h.h
+	h.h = h.newHistogram()
+	h.m.Unlock()
+
+	return old.Snapshot()
+}
+
+func (h *resettableHistogram) current() metrics.Histogram {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	return h.h
+}
+
+// Clear replaces the histogram by a new one.
+func (h *resettableHistogram) Clear() { h.snapshotAndReset() }
+
+// Count returns the number of values recorded since the last reset.
+func (h *resettableHistogram) Count() int64 { return h.current().Count() }
+
+// Max returns the maximal value recorded since the last reset.
+func (h *resettableHistogram) Max() int64 { return h.current().Max() }
+
+// Mean returns the mean of the values recorded since the last reset.
+func (h *resettableHistogram) Mean() float64 { return h.current().Mean() }
+
+// Min returns the minimal value recorded since the last reset.
+func (h *resettableHistogram) Min() int64 { return h.current().Min() }
+
+// Percentile returns the given percentile of the values recorded since the last reset.
+func (h *resettableHistogram) Percentile(p float64) float64 { return h.current().Percentile(p) }
+
+// Percentiles returns the given percentiles of the values recorded since the last reset.
+func (h *resettableHistogram) Percentiles(ps []float64) []float64 {
+	return h.current().Percentiles(ps)
+}
+
+// Sample returns the sample of the current histogram.
+func (h *resettableHistogram) Sample() metrics.Sample { return h.current().Sample() }
+
+// Snapshot returns a read-only copy of the current histogram.
+func (h *resettableHistogram) Snapshot() metrics.Histogram { return h.current().Snapshot() }
+
+// StdDev returns the standard deviation of the values recorded since the last reset.
+func (h *resettableHistogram) StdDev() float64 { return h.current().StdDev() }
+
+// Sum returns the sum of the values recorded since the last reset.
+func (h *resettableHistogram) Sum() int64 { return h.current().Sum() }
+
+// Update records a value in the current histogram. The read lock is held during the update,
+// so a reset waits for it.
+func (h *resettableHistogram) Update(v int64) {
+	h.m.RLock()
+	defer h.m.RUnlock()
+
+	h.h.Update(v)
+}
+
+// Variance returns the variance of the values recorded since the last reset.
+func (h *resettableHistogram) Variance() float64 { return h.current().Variance() }
+
+// resettableBucketed is a resettable histogram.Bucketed
+type resettableBucketed struct {
+	*resettableHistogram
+}
+
+// Buckets returns the upper bounds of the buckets.
+func (h *resettableBucketed) Buckets() []float64 {
+	return h.current().(histogram.Bucketed).Buckets()
+}
+
+// BucketCounts returns the cumulative counts of the buckets since the last reset.
+func (h *resettableBucketed) BucketCounts() []int64 {
+	return h.current().(histogram.Bucketed).BucketCounts()
+}
+
+// resettableTimer is a metrics.Timer whose histogram is replaced by a new one at each reset,
+// its rates being kept
+type resettableTimer struct {
+	m     sync.RWMutex
+	t     metrics.Timer
+	meter metrics.Meter
+}
+
+// NewResettableTimer creates a metrics.Timer which can be reset at each flush, when registered
+// with RegisterResetting or with the tag `metrics_reset:"flush"`. The standard timers cannot be reset.
+func NewResettableTimer() metrics.Timer {
+	meter := metrics.NewMeter()
+	return &resettableTimer{
+		t:     metrics.NewCustomTimer(newTimerHistogram(), meter),
+		meter: meter,
+	}
+}
+
+func newTimerHistogram() metrics.Histogram {
+	return metrics.NewHistogram(metrics.NewExpDecaySample(1028, 0.015))
+}
+
+func (t *resettableTimer) snapshotAndReset() interface{} {
+	t.m.Lock()
+	old := t.t
+	t.t = metrics.NewCustomTimer(newTimerHistogram(), t.meter)
+	t.m.Unlock()
+
+	return old.Snapshot()
+}
+
+func (t *resettableTimer) current() metrics.Timer {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	return t.t
+}
+
+// Count returns the number of durations recorded since the last reset.
+func (t *resettableTimer) Count() int64 { return t.current().Count() }
+
+// Max returns the maximal duration recorded since the last reset.
+func (t *resettableTimer) Max() int64 { return t.current().Max() }
+
+// Mean returns the mean of the durations recorded since the last reset.
+func (t *resettableTimer) Mean() float64 { return t.current().Mean() }
+
+// Min returns the minimal duration recorded since the last reset.
+func (t *resettableTimer) Min() int64 { return t.current().Min() }
+
+// Percentile returns the given percentile of the durations recorded since the last reset.
+func (t *resettableTimer) Percentile(p float64) float64 { return t.current().Percentile(p) }
+
+// Percentiles returns the given percentiles of the durations recorded since the last reset.
+func (t *resettableTimer) Percentiles(ps []float64) []float64 {
+	return t.current().Percentiles(ps)
+}
+
+// Rate1 returns the one-minute moving average rate of events per second.
+func (t *resettableTimer) Rate1() float64 { return t.meter.Rate1() }
+
+// Rate5 returns the five-minute moving average rate of events per second.
+func (t *resettableTimer) Rate5() float64 { return t.meter.Rate5() }
+
+// Rate15 returns the fifteen-minute moving average rate of events per second.
+func (t *resettableTimer) Rate15() float64 { return t.meter.Rate15() }
+
+// RateMean returns the meter's mean rate of events per second.
+func (t *resettableTimer) RateMean() float64 { return t.meter.RateMean() }
+
+// Snapshot returns a read-only copy of the current timer.
+func (t *resettableTimer) Snapshot() metrics.Timer { return t.current().Snapshot() }
+
+// StdDev returns the standard deviation of the durations recorded since the last reset.
+func (t *resettableTimer) StdDev() float64 { return t.current().StdDev() }
+
+// Stop stops the meter of the timer.
+func (t *resettableTimer) Stop() { t.meter.Stop() }
+
+// Sum returns the sum of the durations recorded since the last reset.
+func (t *resettableTimer) Sum() int64 { return t.current().Sum() }
+
+// Time records the duration of the execution of the function given.
+func (t *resettableTimer) Time(f func()) {
+	start := time.Now()
+	f()
+	t.UpdateSince(start)
+}
+
+// Update records a duration in the current timer. The read lock is held during the update,
+// so a reset waits for it.
+func (t *resettableTimer) Update(d time.Duration) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	t.t.Update(d)
+}
+
+// UpdateSince records the duration elapsed since the time given.
+func (t *resettableTimer) UpdateSince(start time.Time) { t.Update(time.Since(start)) }
+
+// Variance returns the variance of the durations recorded since the last reset.
+func (t *resettableTimer) Variance() float64 { return t.current().Variance() }
+
+// resetModeFromTag returns whether the tag `metrics_reset:""` asks to reset the metric at each flush
+func resetModeFromTag(tag string) (bool, error) {
+	switch tag {
+	case "":
+		return false, nil
+	case "flush":
+		return true, nil
+	}
+	return false, ErrUnknownResetMode
+}
+
+// isResettable tells whether the metric can be reset at each flush. The metrics other than the
+// histograms and the timers are never reset.
+func isResettable(i interface{}) bool {
+	switch i.(type) {
+	case metrics.Histogram, metrics.Timer:
+		_, ok := i.(resettable)
+		return ok
+	}
+	return true
+}
+
+// snapshotRegistry returns a copy of the registry where the histograms and timers to reset are
+// replaced by a snapshot of their values, and reset. The other metrics are kept as is.
+func snapshotRegistry(r metrics.Registry, resetAll bool) metrics.Registry {
+	rr, hasResets := r.(resettingRegistry)
+	hasResets = hasResets && rr.hasResets()
+	if !resetAll && !hasResets {
+		return r
+	}
+
+	mr, hasMetadata := r.(driver.MetadataRegistry)
+	ret := newMetadataRegistry()
+	r.Each(func(name string, i interface{}) {
+		if resetAll || (hasResets && rr.resetOnFlush(name)) {
+			if rm, ok := i.(resettable); ok {
+				i = rm.snapshotAndReset()
+			} else if !isResettable(i) {
+				log.Debugf("[metrics] metric %s cannot be reset : %s", name, ErrNotResettable)
+			}
+		}
+
+		var md driver.Metadata
+		if hasMetadata {
+			md, _ = mr.Metadata(name)
+		}
+		ret.registerWithMetadata(name, i, md)
+	})
+
+	return ret
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+var (
+	ErrNotTaggedRegistry error = errors.New("registry not created by NewTaggedRegistry or RegistryFromStruct")
+)
+
+// NewMetric instantiates a metric of the type given, configured by the tags `metrics_sample:""`,
+// `metrics_buckets:""` and `metrics_reset:""` of the struct tag given, as RegistryFromStruct does
+// for a field of this type. The type is the one of the field, qualified by the name of its package :
+// metrics.Counter, metrics.Gauge, metrics.GaugeFloat64, metrics.Meter, metrics.Timer and
+// metrics.Histogram of github.com/rcrowley/go-metrics, histogram.Bucketed, or one of the families
+// of this package such as metrics.CounterFamily, which is returned by pointer.
+//
+// It is the parser of the tags shared by RegistryFromStruct and metricsgen.
+func NewMetric(metricType string, tag reflect.StructTag) (interface{}, error) {
+	switch metricType {
+
+	case "metrics.Counter":
+		return metrics.NewCounter(), nil
+
+	case "metrics.Gauge":
+		return metrics.NewGauge(), nil
+
+	case "metrics.GaugeFloat64":
+		return metrics.NewGaugeFloat64(), nil
+
+	case "metrics.Meter":
+		return metrics.NewMeter(), nil
+
+	case "metrics.Timer":
+		if tag.Get("metrics_reset") == "flush" {
+			return NewResettableTimer(), nil
+		}
+		return metrics.NewTimer(), nil
+
+	case "metrics.Histogram":
+		newFunc := func() (metrics.Histogram, error) {
+			return newHistogram(tag.Get("metrics_sample"), tag.Get("metrics_sample_value"))
+		}
+		return newMaybeResettable(newFunc, tag)
+
+	case "histogram.Bucketed":
+		newFunc := func() (metrics.Histogram, error) {
+			return newBucketed(tag.Get("metrics_buckets"), tag.Get("metrics_buckets_value"))
+		}
+		return newMaybeResettable(newFunc, tag)
+
+	case "metrics.CounterFamily":
+		return &CounterFamily{}, nil
+
+	case "metrics.GaugeFamily":
+		return &GaugeFamily{}, nil
+
+	case "metrics.GaugeFloat64Family":
+		return &GaugeFloat64Family{}, nil
+
+	case "metrics.MeterFamily":
+		return &MeterFamily{}, nil
+
+	case "metrics.TimerFamily":
+		return &TimerFamily{}, nil
+
+	case "metrics.HistogramFamily":
+		return &HistogramFamily{}, nil
+
+	case "metrics.BucketedFamily":
+		return &BucketedFamily{}, nil
+	}
+
+	return nil, ErrMetricsTypeUnhandled
+}
+
+// MustNewMetric works as NewMetric but panics if the metric cannot be instantiated. It is used
+// by the code generated by metricsgen, whose tags are checked at generation time.
+func MustNewMetric(metricType string, tag reflect.StructTag) interface{} {
+	i, err := NewMetric(metricType, tag)
+	if err != nil {
+		panic(fmt.Sprintf("metrics: cannot instantiate %s : %s", metricType, err))
+	}
+	return i
+}
+
+// NewTaggedRegistry creates an empty registry keeping the metadata of the metrics registered
+// with RegisterMetric, as the registries created by RegistryFromStruct.
+func NewTaggedRegistry() metrics.Registry {
+	return newMetadataRegistry()
+}
+
+// RegisterMetric registers the metric in the registry, created by NewTaggedRegistry or
+// RegistryFromStruct, with the tags `metrics_tags:""`, `metrics_help:""`, `metrics_unit:""`,
+// `metrics_reset:""` and `metrics_label:""` of the struct tag given, as RegistryFromStruct does
+// for a field. The families are given by pointer.
+func RegisterMetric(r metrics.Registry, name string, i interface{}, tag reflect.StructTag) error {
+	mr, ok := r.(*metadataRegistry)
+	if !ok {
+		return ErrNotTaggedRegistry
+	}
+
+	md, err := metadataFromTag(tag)
+	if err != nil {
+		return err
+	}
+	reset, err := resetModeFromTag(tag.Get("metrics_reset"))
+	if err != nil {
+		return err
+	}
+
+	if _, ok := i.(labeledFamily); ok {
+		return registerFamily(mr, name, reflect.ValueOf(i), tag, md)
+	}
+
+	if reset && !isResettable(i) {
+		return ErrNotResettable
+	}
+	if err := mr.registerWithMetadata(name, i, md); err != nil {
+		return err
+	}
+	if reset {
+		mr.setResetOnFlush(name)
+	}
+	return nil
+}